@@ -1,35 +1,67 @@
 package gonf
 
 import (
+	"bytes"
+	"encoding"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 )
 
 var (
-	errNilTarget      = errors.New("no configuration target supplied...")
-	errEmptyConfig    = errors.New("no configuration file...")
-	errNoChanges      = errors.New("the configuration file has not changed...")
-	errEmptyName      = errors.New("name cannot be empty...")
-	errNoEnvOptions   = errors.New("environment variable must not be empty or at least one command line option is expected...")
-	errBadNameSyntax  = errors.New("bad syntax for child properties...")
-	errConflictingAdd = errors.New("duplicate option detected...")
-
 	fmtPrintf = fmt.Printf
 	readfile  = ioutil.ReadFile
 	mkdirall  = os.MkdirAll
-	create    = os.Create
+	tempfile  = ioutil.TempFile
+	rename    = os.Rename
 	stat      = os.Stat
 	exit      = os.Exit
+
+	durationType    = reflect.TypeOf(time.Duration(0))
+	jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Controls which values Save writes, and may be combined with bitwise or.
+type SaveMode uint8
+
+// Write every value of the target, which is the default.
+const SaveAll SaveMode = 0
+
+const (
+	// Write only values which differ from the defaults held by the target
+	// when it was supplied to Target.
+	SaveChanges SaveMode = 1 << iota
+
+	// Leave out values supplied by environment variables or command line
+	// options, so they are not persisted to the file.
+	SaveOmitInputs
+)
+
+// Controls what Load does when none of the files it searches for exist.
+type FirstRun uint8
+
+const (
+	// Save the defaults of the target to a new file, which is the default.
+	FirstRunCreate FirstRun = iota
+
+	// Continue loading without a file, and without creating one.
+	FirstRunSkip
+
+	// Continue loading without a file, but return an error for it.
+	FirstRunRequire
 )
 
 type locker interface {
@@ -37,6 +69,28 @@ type locker interface {
 	Unlock()
 }
 
+// Accumulates values supplied by command line options, which unlike
+// environment variables are never split using the separator.
+type repeated []interface{}
+
+// Describes a setting that was changed by Reload using dot-notation, along
+// with the values before and after the change.
+type Change struct {
+	Name string
+	Old  interface{}
+	New  interface{}
+}
+
+type subscription struct {
+	name string
+	fn   func(interface{}, interface{})
+}
+
+type validation struct {
+	name  string
+	rules []Rule
+}
+
 // A simple interface for configuration, which expects a Target pointer to a
 // structure which it can apply registered settings against, and a description
 // which will enable automatically generated help and register related options.
@@ -48,6 +102,22 @@ type Config struct {
 	configModified time.Time
 	examples       []string
 	settings       []setting
+	separator      string
+	prefix         string
+	validations    []validation
+	refresh        bool
+	options        map[string]interface{}
+	environment    map[string]interface{}
+	hooks          []func([]Change)
+	subscriptions  []subscription
+	origins        []map[string]string
+	explain        bool
+	dump           bool
+	mode           os.FileMode
+	timeout        time.Duration
+	save           SaveMode
+	first          FirstRun
+	defaults       interface{}
 }
 
 func (c *Config) isNumeric(t reflect.Kind) bool {
@@ -58,56 +128,378 @@ func (c *Config) isNumeric(t reflect.Kind) bool {
 	return false
 }
 
-func (c *Config) convert(d reflect.Value, v interface{}) interface{} {
+func (c *Config) split(v string) []interface{} {
+	sep := c.separator
+	if sep == "" {
+		sep = ","
+	}
+	var s []interface{}
+	for _, e := range strings.Split(v, sep) {
+		s = append(s, e)
+	}
+	return s
+}
+
+func (c *Config) number(name string, d reflect.Value, v string) (interface{}, error) {
+	var r interface{}
+	var err error
+	switch d.Kind() {
+	case reflect.Float32, reflect.Float64:
+		r, err = strconv.ParseFloat(v, d.Type().Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if strings.HasPrefix(v, "-") {
+			return nil, &ConversionError{Setting: name, Err: fmt.Errorf("%s is negative for %s", v, d.Type())}
+		}
+		r, err = strconv.ParseUint(v, 10, d.Type().Bits())
+	default:
+		r, err = strconv.ParseInt(v, 10, d.Type().Bits())
+	}
+	if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrRange {
+		return nil, &ConversionError{Setting: name, Err: fmt.Errorf("%s overflows %s", v, d.Type())}
+	} else if err != nil {
+		return nil, &ConversionError{Setting: name, Err: err}
+	}
+	return r, nil
+}
+
+func (c *Config) unmarshal(name string, d reflect.Value, v string) (interface{}, error) {
+	t := d.Type()
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	if u, ok := reflect.New(t.Elem()).Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(v)); err != nil {
+			return nil, &ConversionError{Setting: name, Err: err}
+		}
+		return v, nil
+	}
+	raw, _ := json.Marshal(v)
+	if err := reflect.New(t.Elem()).Interface().(json.Unmarshaler).UnmarshalJSON(raw); err != nil {
+		return nil, &ConversionError{Setting: name, Err: err}
+	}
+	return json.RawMessage(raw), nil
+}
+
+func (c *Config) isCustom(t reflect.Type) bool {
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	return t.Implements(jsonUnmarshaler) || t.Implements(textUnmarshaler)
+}
+
+func (c *Config) convert(name string, d reflect.Value, v interface{}) (interface{}, error) {
+	if v == nil {
+		return v, nil
+	}
 	t := d.Kind()
+	custom := c.isCustom(d.Type())
+	if r, ok := v.(repeated); ok && (custom || t != reflect.Slice && t != reflect.Map || t == reflect.Slice && d.Type().Elem().Kind() == reflect.Uint8) {
+		v = r[len(r)-1]
+	}
 	in := reflect.TypeOf(v).Kind()
+	n, number := v.(json.Number)
 	switch {
+	case in == reflect.String && custom && !number:
+		return c.unmarshal(name, d, reflect.ValueOf(v).String())
+	case custom:
+		return v, nil
+	case t == reflect.Ptr:
+		return c.convert(name, reflect.New(d.Type().Elem()).Elem(), v)
+	case number && c.isNumeric(t):
+		return c.number(name, d, n.String())
+	case number:
+		return v, nil
+	case in == reflect.String && d.Type() == durationType:
+		r, err := time.ParseDuration(reflect.ValueOf(v).String())
+		if err != nil {
+			return nil, &ConversionError{Setting: name, Err: err}
+		}
+		return int64(r), nil
 	case in == reflect.String && t == reflect.Bool:
-		if r, err := strconv.ParseBool(v.(string)); err == nil {
-			return r
+		r, err := strconv.ParseBool(v.(string))
+		if err != nil {
+			return nil, &ConversionError{Setting: name, Err: err}
 		}
+		return r, nil
 	case in == reflect.String && c.isNumeric(t):
-		if r, err := strconv.ParseFloat(v.(string), 64); err == nil {
-			return r
-		}
+		return c.number(name, d, v.(string))
 	case in == reflect.Map && t == reflect.Struct:
 		if p, ok := v.(map[string]interface{}); ok {
-			c.cast(d.Addr().Interface(), p, map[string]interface{}{})
-			return p
+			return p, c.cast(name+".", d.Addr().Interface(), p)
+		}
+	case in == reflect.String && t == reflect.Slice && d.Type().Elem().Kind() != reflect.Uint8:
+		return c.convert(name, d, c.split(reflect.ValueOf(v).String()))
+	case in == reflect.Slice && t == reflect.Slice:
+		s := reflect.ValueOf(v)
+		r := make([]interface{}, s.Len())
+		for i := range r {
+			e, err := c.convert(name, reflect.New(d.Type().Elem()).Elem(), s.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			r[i] = e
+		}
+		return r, nil
+	case in == reflect.String && t == reflect.Map:
+		return c.convert(name, d, repeated(c.split(reflect.ValueOf(v).String())))
+	case in == reflect.Slice && t == reflect.Map:
+		if r, ok := v.(repeated); ok {
+			p := make(map[string]interface{}, len(r))
+			for _, e := range r {
+				kv := append(strings.SplitN(fmt.Sprint(e), "=", 2), "")
+				p[kv[0]] = kv[1]
+			}
+			return c.convert(name, d, p)
+		}
+	case in == reflect.Map && t == reflect.Map:
+		if p, ok := v.(map[string]interface{}); ok {
+			var errs []error
+			for k, e := range p {
+				r, err := c.convert(name+"."+k, reflect.New(d.Type().Elem()).Elem(), e)
+				if err != nil {
+					delete(p, k)
+				} else {
+					p[k] = r
+				}
+				errs = append(errs, err)
+			}
+			return p, c.join(errs...)
 		}
 	}
-	return v
+	return v, nil
 }
 
-func (c *Config) cast(o interface{}, m map[string]interface{}, discard map[string]interface{}) {
+func (c *Config) field(d reflect.Value, k string) (reflect.Value, bool) {
+	for i := 0; i < d.NumField(); i++ {
+		if n := strings.Split(d.Type().Field(i).Tag.Get("json"), ",")[0]; n != "-" && n == k {
+			return d.Field(i), true
+		}
+	}
+	for i := 0; i < d.NumField(); i++ {
+		if n := strings.Split(d.Type().Field(i).Tag.Get("json"), ",")[0]; n != "-" && k == d.Type().Field(i).Name {
+			return d.Field(i), true
+		}
+	}
+	for i := 0; i < d.NumField(); i++ {
+		if t := strings.Split(d.Type().Field(i).Tag.Get("json"), ",")[0]; t != "" || d.Field(i).Kind() != reflect.Struct || !d.Type().Field(i).Anonymous {
+			continue
+		}
+		if f, ok := c.field(d.Field(i), k); ok {
+			return f, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func (c *Config) path(t reflect.Type, name string) string {
+	keys := strings.Split(name, ".")
+	for i, k := range keys {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Map || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		} else if t.Kind() != reflect.Struct {
+			break
+		} else if f, ok := c.field(reflect.New(t).Elem(), k); ok {
+			if sf, ok := t.FieldByName(k); ok {
+				if n := strings.Split(sf.Tag.Get("json"), ",")[0]; n != "" {
+					keys[i] = n
+				}
+			}
+			t = f.Type()
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+func (c *Config) canonical(name string) string {
+	if c.target == nil {
+		return name
+	}
+	return c.path(reflect.TypeOf(c.target).Elem(), name)
+}
+
+func (c *Config) secrets() map[string]bool {
+	secrets := map[string]bool{}
+	for _, s := range c.settings {
+		if s.Secret {
+			secrets[c.canonical(s.Name)] = true
+		}
+	}
+	return secrets
+}
+
+func (c *Config) required() map[string]bool {
+	required := map[string]bool{}
+	for _, s := range c.settings {
+		if s.Required {
+			required[c.canonical(s.Name)] = true
+		}
+	}
+	return required
+}
+
+func (c *Config) secret(name string, secrets map[string]bool) bool {
+	for p := name; ; p = p[:strings.LastIndex(p, ".")] {
+		if secrets[p] {
+			return true
+		} else if !strings.Contains(p, ".") {
+			return false
+		}
+	}
+}
+
+func (c *Config) redact(v interface{}, prefix string, secrets map[string]bool, omit bool) {
+	if l, ok := v.([]interface{}); ok {
+		for _, e := range l {
+			c.redact(e, prefix, secrets, omit)
+		}
+		return
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for k, e := range m {
+		if secrets[prefix+k] && omit {
+			delete(m, k)
+		} else if secrets[prefix+k] {
+			m[k] = "***"
+		} else {
+			c.redact(e, prefix+k+".", secrets, omit)
+		}
+	}
+}
+
+func (c *Config) walk(t reflect.Type, prefix string, fn func(string, reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		n := strings.Split(f.Tag.Get("json"), ",")[0]
+		if n == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		} else if n == "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			c.walk(f.Type, prefix, fn)
+			continue
+		} else if n == "" {
+			n = f.Name
+		}
+		fn(prefix+n, f)
+		if f.Type.Kind() == reflect.Struct && !c.isCustom(f.Type) {
+			c.walk(f.Type, prefix+n+".", fn)
+		}
+	}
+}
+
+func (c *Config) cast(prefix string, o interface{}, m map[string]interface{}) error {
+	var errs []error
 	d := reflect.ValueOf(o).Elem()
 	for k, v := range m {
-		if _, ok := discard[k]; ok {
+		f, ok := c.field(d, k)
+		if !ok {
 			continue
 		}
-		for i := 0; i < d.NumField(); i++ {
-			if n := strings.Split(d.Type().Field(i).Tag.Get("json"), ",")[0]; n == "-" || n != k {
-				continue
+		if r, err := c.convert(prefix+k, f, v); err != nil {
+			delete(m, k)
+			errs = append(errs, err)
+		} else {
+			m[k] = r
+		}
+	}
+	return c.join(errs...)
+}
+
+func (c *Config) join(errs ...error) error {
+	var msg Errors
+	for _, e := range errs {
+		if m, ok := e.(Errors); ok {
+			msg = append(msg, m...)
+		} else if e != nil {
+			msg = append(msg, e)
+		}
+	}
+	switch len(msg) {
+	case 0:
+		return nil
+	case 1:
+		return msg[0]
+	}
+	return msg
+}
+
+func (c *Config) humanize(d reflect.Value, v interface{}) interface{} {
+	if d.Kind() == reflect.Ptr {
+		d = reflect.New(d.Type().Elem()).Elem()
+	}
+	switch p := v.(type) {
+	case json.Number:
+		if n, err := p.Int64(); err == nil && d.Type() == durationType {
+			return time.Duration(n).String()
+		}
+	case []interface{}:
+		if d.Kind() == reflect.Slice || d.Kind() == reflect.Array {
+			for i, e := range p {
+				p[i] = c.humanize(reflect.New(d.Type().Elem()).Elem(), e)
 			}
-			discard[k] = struct{}{}
-			m[k] = c.convert(d.Field(i), v)
 		}
-		if _, ok := discard[k]; ok {
-			continue
+	case map[string]interface{}:
+		if d.Kind() != reflect.Map && d.Kind() != reflect.Struct {
+			break
 		}
-		for i := 0; i < d.NumField(); i++ {
-			if k != d.Type().Field(i).Name {
-				continue
+		for k, e := range p {
+			if d.Kind() == reflect.Map {
+				p[k] = c.humanize(reflect.New(d.Type().Elem()).Elem(), e)
+			} else if f, ok := c.field(d, k); ok && d.Kind() == reflect.Struct {
+				p[k] = c.humanize(f, e)
 			}
-			discard[k] = struct{}{}
-			m[k] = c.convert(d.Field(i), v)
 		}
 	}
-	for i := 0; i < d.NumField(); i++ {
-		if t := strings.Split(d.Type().Field(i).Tag.Get("json"), ",")[0]; t != "" || d.Field(i).Kind() != reflect.Struct || !d.Type().Field(i).Anonymous || len(m) <= len(discard) {
-			continue
+	return v
+}
+
+func (c *Config) export(t interface{}) (interface{}, error) {
+	var v interface{}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return v, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || t == nil {
+		return v, err
+	}
+	return c.humanize(reflect.ValueOf(t).Elem(), v), nil
+}
+
+func (c *Config) prune(v, d interface{}, t reflect.Type) {
+	m, ok := v.(map[string]interface{})
+	dm, is := d.(map[string]interface{})
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !ok || !is || t == nil || t.Kind() != reflect.Struct {
+		return
+	}
+	for k, e := range m {
+		if x, ok := dm[k]; ok && reflect.DeepEqual(e, x) {
+			delete(m, k)
+		} else if ft := c.element(t, k); ft != nil && ft.Kind() == reflect.Struct {
+			c.prune(e, x, ft)
+		}
+	}
+}
+
+func (c *Config) inputs(v interface{}, prefix string, t reflect.Type) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(c.origins) < 2 {
+		return
+	}
+	for k, e := range m {
+		if ft := c.element(t, k); ft != nil && ft.Kind() == reflect.Struct {
+			c.inputs(e, prefix+k+".", ft)
+		} else if c.source(prefix+k, c.origins[1:]) != "default" {
+			delete(m, k)
 		}
-		c.cast(reflect.New(d.Field(i).Type()).Interface(), m, discard)
 	}
 }
 
@@ -128,27 +520,256 @@ func (c *Config) merge(maps ...map[string]interface{}) map[string]interface{} {
 	return m
 }
 
-func (c *Config) to(data ...map[string]interface{}) error {
+func (c *Config) clone(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.New(v.Type().Elem())
+		n.Elem().Set(c.clone(v.Elem()))
+		return n
+	case reflect.Struct:
+		n := reflect.New(v.Type()).Elem()
+		n.Set(v)
+		for i := 0; i < n.NumField(); i++ {
+			if n.Field(i).CanSet() {
+				n.Field(i).Set(c.clone(v.Field(i)))
+			}
+		}
+		return n
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			n.Index(i).Set(c.clone(v.Index(i)))
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		n := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			n.SetMapIndex(k, c.clone(v.MapIndex(k)))
+		}
+		return n
+	}
+	return v
+}
+
+func (c *Config) lookup(v reflect.Value, name string) (reflect.Value, bool) {
+	for _, k := range strings.Split(name, ".") {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			f, ok := c.field(v, k)
+			if !ok || !f.CanInterface() {
+				return f, false
+			}
+			v = f
+		case reflect.Map:
+			if v = v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key())); !v.IsValid() {
+				return v, false
+			}
+		default:
+			return v, false
+		}
+	}
+	return v, true
+}
+
+func (c *Config) flatten(prefix string, m map[string]interface{}, origin string, o map[string]string) {
+	for k, v := range m {
+		if p, ok := v.(map[string]interface{}); ok && len(p) > 0 {
+			c.flatten(prefix+k+".", p, origin, o)
+		} else {
+			o[prefix+k] = origin
+		}
+	}
+}
+
+func (c *Config) source(name string, layers []map[string]string) string {
+	name = c.canonical(name)
+	origins := make([]map[string]string, len(layers))
+	for i := range layers {
+		origins[i] = map[string]string{}
+		for k, v := range layers[i] {
+			origins[i][c.canonical(k)] = v
+		}
+	}
+	for i := len(origins) - 1; i >= 0; i-- {
+		if s, ok := origins[i][name]; ok {
+			return s
+		}
+		for p := name; strings.Contains(p, "."); {
+			p = p[:strings.LastIndex(p, ".")]
+			if s, ok := origins[i][p]; ok {
+				return s
+			}
+		}
+		var children []string
+		for k := range origins[i] {
+			if strings.HasPrefix(k, name+".") {
+				children = append(children, k)
+			}
+		}
+		if len(children) > 0 {
+			sort.Strings(children)
+			return origins[i][children[0]]
+		}
+	}
+	return "default"
+}
+
+func (c *Config) validate(v reflect.Value, origins []map[string]string) error {
+	var errs []error
+	for _, r := range c.validations {
+		f, ok := c.lookup(v, r.name)
+		if !ok {
+			errs = append(errs, &ValidationError{Setting: r.name, Source: c.source(r.name, origins), Err: ErrNotFound})
+			continue
+		}
+		for _, rule := range r.rules {
+			if err := rule(f.Interface()); err != nil {
+				errs = append(errs, &ValidationError{Setting: r.name, Source: c.source(r.name, origins), Err: err})
+			}
+		}
+	}
+	return c.join(errs...)
+}
+
+func (c *Config) swap(dst, src reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		if f := dst.Field(i); !f.CanSet() {
+			continue
+		} else if f.Kind() == reflect.Struct && !c.isCustom(f.Type()) {
+			c.swap(f, src.Field(i))
+		} else {
+			f.Set(src.Field(i))
+		}
+	}
+}
+
+func (c *Config) diff(a, b reflect.Value) []Change {
+	var changes []Change
+	c.walk(a.Type(), "", func(name string, f reflect.StructField) {
+		if f.Type.Kind() == reflect.Struct && !c.isCustom(f.Type) {
+			return
+		}
+		o, ook := c.lookup(a, name)
+		n, nok := c.lookup(b, name)
+		switch {
+		case !ook && !nok:
+		case !ook:
+			changes = append(changes, Change{Name: name, New: c.clone(n).Interface()})
+		case !nok:
+			changes = append(changes, Change{Name: name, Old: c.clone(o).Interface()})
+		case !reflect.DeepEqual(o.Interface(), n.Interface()):
+			changes = append(changes, Change{Name: name, Old: c.clone(o).Interface(), New: c.clone(n).Interface()})
+		}
+	})
+	return changes
+}
+
+func (c *Config) value(v reflect.Value, name string) interface{} {
+	if f, ok := c.lookup(v, name); ok {
+		return c.clone(f).Interface()
+	}
+	return nil
+}
+
+func (c *Config) subscribed(a, b reflect.Value) []func() {
+	var fire []func()
+	for _, s := range c.subscriptions {
+		o, n, fn := c.value(a, s.name), c.value(b, s.name), s.fn
+		if !reflect.DeepEqual(o, n) {
+			fire = append(fire, func() { fn(o, n) })
+		}
+	}
+	return fire
+}
+
+func (c *Config) to(reload bool, origins []map[string]string, data ...map[string]interface{}) ([]Change, []func(), error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.target == nil {
-		return errNilTarget
+		return nil, nil, ErrNilTarget
 	}
-	combo := c.merge(data...)
-	if l, e := c.target.(locker); e {
+	var errs []error
+	for i, d := range data {
+		err := c.join(c.cast("", c.target, d))
+		list, ok := err.(Errors)
+		if !ok && err != nil {
+			list = Errors{err}
+		}
+		for _, e := range list {
+			if ce, ok := e.(*ConversionError); ok && i < len(origins) {
+				ce.Source = c.source(ce.Setting, origins[i:i+1])
+				name := c.canonical(ce.Setting)
+				for k := range origins[i] {
+					if n := c.canonical(k); n == name || strings.HasPrefix(n, name+".") {
+						delete(origins[i], k)
+					}
+				}
+			}
+		}
+		errs = append(errs, err)
+	}
+	if err := c.join(errs...); err != nil && reload {
+		return nil, nil, err
+	}
+	l, e := c.target.(locker)
+	if e {
+		l.Lock()
+	}
+	n := c.clone(reflect.ValueOf(c.target))
+	if e {
+		l.Unlock()
+	}
+	final, _ := json.Marshal(c.merge(data...))
+	if err := json.Unmarshal(final, n.Interface()); err != nil && reload {
+		return nil, nil, c.join(append(errs, &ApplyError{Err: err})...)
+	} else if err != nil {
+		errs = append(errs, &ApplyError{Err: err})
+	}
+	if err := c.validate(n.Elem(), origins); err != nil {
+		return nil, nil, c.join(append(errs, err)...)
+	}
+	if e {
 		l.Lock()
 		defer l.Unlock()
 	}
-	c.cast(c.target, combo, map[string]interface{}{})
-	final, _ := json.Marshal(combo)
-	return json.Unmarshal(final, c.target)
+	changes := c.diff(reflect.ValueOf(c.target).Elem(), n.Elem())
+	fire := c.subscribed(reflect.ValueOf(c.target).Elem(), n.Elem())
+	c.swap(reflect.ValueOf(c.target).Elem(), n.Elem())
+	return changes, fire, c.join(errs...)
 }
 
 func (c *Config) set(cursor map[string]interface{}, key string, value interface{}) {
 	keys := strings.Split(key, ".")
 	for i, k := range keys {
 		if i+1 == len(keys) {
-			cursor[k] = value
+			if e, ok := cursor[k]; ok {
+				r, is := e.(repeated)
+				if !is {
+					r = repeated{e}
+				}
+				if v, is := value.(repeated); is {
+					cursor[k] = append(r, v...)
+				} else {
+					cursor[k] = append(r, value)
+				}
+			} else {
+				cursor[k] = value
+			}
 		} else {
 			if _, ok := cursor[k]; !ok {
 				cursor[k] = map[string]interface{}{}
@@ -158,14 +779,87 @@ func (c *Config) set(cursor map[string]interface{}, key string, value interface{
 	}
 }
 
-func (c *Config) parseEnvs() map[string]interface{} {
-	vars := make(map[string]interface{})
+func (c *Config) snake(name string) string {
+	var b []rune
+	r := []rune(name)
+	for i, l := range r {
+		if l == '.' || l == '-' {
+			l = '_'
+		} else if i > 0 && unicode.IsUpper(l) && (unicode.IsLower(r[i-1]) || unicode.IsDigit(r[i-1]) || i+1 < len(r) && unicode.IsUpper(r[i-1]) && unicode.IsLower(r[i+1])) {
+			b = append(b, '_')
+		}
+		b = append(b, unicode.ToUpper(l))
+	}
+	return string(b)
+}
+
+func (c *Config) derived() []setting {
+	var envs []setting
+	if c.prefix == "" || c.target == nil {
+		return envs
+	}
+	c.walk(reflect.TypeOf(c.target).Elem(), "", func(name string, f reflect.StructField) {
+		if f.Type.Kind() == reflect.Struct && !c.isCustom(f.Type) {
+			return
+		}
+		d := setting{Name: name, Description: name, Env: c.snake(c.prefix + "_" + name)}
+		for _, s := range c.settings {
+			if c.canonical(s.Name) == name && s.Env != "" {
+				return
+			} else if c.canonical(s.Name) == name && s.Description != "" {
+				d.Description = s.Description
+			}
+		}
+		envs = append(envs, d)
+	})
+	return envs
+}
+
+func (c *Config) has(m map[string]interface{}, prefix, key string) bool {
+	for k, v := range m {
+		n := c.canonical(prefix + k)
+		if strings.EqualFold(n, key) {
+			return true
+		} else if sub, ok := v.(map[string]interface{}); ok && len(key) > len(n) && strings.EqualFold(key[:len(n)+1], n+".") && c.has(sub, prefix+k+".", key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) missing(data ...map[string]interface{}) error {
+	var names []string
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	for _, s := range c.settings {
+		if !s.Required {
+			continue
+		}
+		found := false
+		for _, d := range data {
+			found = found || c.has(d, "", c.canonical(s.Name))
+		}
+		if !found {
+			names = append(names, s.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to supply %s, %w", strings.Join(names, ", "), ErrMissingRequired)
+}
+
+func (c *Config) parseEnvs(o map[string]string) map[string]interface{} {
+	vars := make(map[string]interface{})
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, s := range append(c.derived(), c.settings...) {
 		if s.Env == "" {
 			continue
 		}
 		if v := os.Getenv(s.Env); len(v) > 0 {
 			c.set(vars, s.Name, v)
+			o[s.Name] = s.Env
 		}
 	}
 	return vars
@@ -180,9 +874,17 @@ func (c *Config) help(discontinue bool) {
 	fmtPrintf("[%s]\nDescription:\n\t%s\n", appName, c.description)
 	fmtPrintf("\n\nFlags:\n")
 	fmtPrintf("\t%s\n\t\t%s\n\n", "help, -h, --help", "display help information")
+	fmtPrintf("\t%s\n\t\t%s\n\n", "--explain-config", "display every setting with its value and source")
+	fmtPrintf("\t%s\n\t\t%s\n\n", "--print-config", "display the effective configuration")
 	for _, o := range c.settings {
 		fmtPrintf("%s\n\n", o)
 	}
+	if envs := c.derived(); len(envs) > 0 {
+		fmtPrintf("\nEnvironment:\n")
+		for _, o := range envs {
+			fmtPrintf("%s\n\n", o)
+		}
+	}
 	if len(c.examples) > 0 {
 		fmtPrintf("\nUsage:\n\n")
 	}
@@ -195,26 +897,78 @@ func (c *Config) help(discontinue bool) {
 	}
 }
 
-func (c *Config) parseLong(i *int, m map[string]interface{}) {
+func (c *Config) explained(discontinue bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.description == "" || c.target == nil {
+		return
+	}
+	if l, e := c.target.(locker); e {
+		l.Lock()
+		defer l.Unlock()
+	}
+	secrets := c.secrets()
+	fmtPrintf("[%s]\nConfiguration:\n", appName)
+	c.walk(reflect.TypeOf(c.target).Elem(), "", func(name string, f reflect.StructField) {
+		if f.Type.Kind() == reflect.Struct && !c.isCustom(f.Type) {
+			return
+		}
+		v := c.value(reflect.ValueOf(c.target).Elem(), name)
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		if c.secret(name, secrets) {
+			v = "***"
+		}
+		data, _ := json.Marshal(v)
+		fmtPrintf("\t%-30s %s (%s)\n", name, data, c.source(name, c.origins))
+	})
+	fmtPrintf("\n")
+	if discontinue {
+		exit(0)
+	}
+}
+
+func (c *Config) printed(discontinue bool) {
+	c.mu.RLock()
+	description := c.description
+	c.mu.RUnlock()
+	if description == "" {
+		return
+	}
+	var b bytes.Buffer
+	if err := c.Dump(&b, "json"); err != nil {
+		fmtPrintf("failed to print configuration, %s\n", err)
+		exit(1)
+		return
+	}
+	fmtPrintf("%s", b.String())
+	if discontinue {
+		exit(0)
+	}
+}
+
+func (c *Config) parseLong(i *int, m map[string]interface{}, o map[string]string) {
 	var y, greedy bool
 	argv := strings.SplitN(os.Args[*i], "=", 2)
 	for _, s := range c.settings {
 		if y, greedy = s.Match(argv[0]); !y {
 			continue
 		}
+		o[s.Name] = fmt.Sprintf("%s (argument %d)", argv[0], *i)
 		switch {
 		case len(argv) == 1 && *i+1 < len(os.Args) && os.Args[*i+1] != "--" && (!strings.HasPrefix(os.Args[*i+1], "-") || greedy):
 			*i++
-			c.set(m, s.Name, os.Args[*i])
+			c.set(m, s.Name, repeated{os.Args[*i]})
 		case len(argv) == 2 && argv[1] != "":
-			c.set(m, s.Name, argv[1])
+			c.set(m, s.Name, repeated{argv[1]})
 		default:
 			c.set(m, s.Name, true)
 		}
 	}
 }
 
-func (c *Config) parseShort(i *int, m map[string]interface{}) {
+func (c *Config) parseShort(i *int, m map[string]interface{}, o map[string]string) {
 	var y, greedy bool
 	a := strings.TrimPrefix(os.Args[*i], "-")
 	for ci, cl := range a {
@@ -222,12 +976,13 @@ func (c *Config) parseShort(i *int, m map[string]interface{}) {
 			if y, greedy = s.Match("-" + string(cl)); !y {
 				continue
 			}
+			o[s.Name] = fmt.Sprintf("-%c (argument %d)", cl, *i)
 			switch {
 			case ci+1 >= len(a) && *i+1 < len(os.Args) && os.Args[*i+1] != "--" && (!strings.HasPrefix(os.Args[*i+1], "-") || greedy):
 				*i++
-				c.set(m, s.Name, os.Args[*i])
+				c.set(m, s.Name, repeated{os.Args[*i]})
 			case ci+1 < len(a) && greedy:
-				c.set(m, s.Name, a[ci+1:])
+				c.set(m, s.Name, repeated{a[ci+1:]})
 				return
 			default:
 				c.set(m, s.Name, true)
@@ -236,51 +991,117 @@ func (c *Config) parseShort(i *int, m map[string]interface{}) {
 	}
 }
 
-func (c *Config) parseOptions() map[string]interface{} {
+func (c *Config) parseOptions(o map[string]string) map[string]interface{} {
 	vars := map[string]interface{}{}
+	explain, dump := false, false
 	for i := 0; i < len(os.Args); i++ {
 		if arg := os.Args[i]; arg == "--" {
 			break
 		} else if arg == "help" || arg == "-h" || arg == "--help" {
 			c.help(true)
+		} else if arg == "--explain-config" {
+			explain = true
+			continue
+		} else if arg == "--print-config" {
+			dump = true
+			continue
 		} else if len(arg) == 1 || !strings.HasPrefix(arg, "-") {
 			continue
 		}
 		if arg := os.Args[i]; strings.HasPrefix(arg, "--") {
-			c.parseLong(&i, vars)
+			c.parseLong(&i, vars, o)
 		} else {
-			c.parseShort(&i, vars)
+			c.parseShort(&i, vars, o)
 		}
 	}
+	c.mu.Lock()
+	c.explain, c.dump = explain, dump
+	c.mu.Unlock()
 	return vars
 }
 
 func (c *Config) comment(data []byte) []byte {
 	re := regexp.MustCompile(`(?:/\*[^*]*\*+(?:[^/*][^*]*\*+)*/|//[^\n]*(?:\n|$)|#[^\n]*(?:\n|$))|("[^"\\]*(?:\\[\S\s][^"\\]*)*"|'[^'\\]*(?:\\[\S\s][^'\\]*)*'|[\S\s][^/"'\\]*)`)
-	return re.ReplaceAll(data, []byte("$1"))
+	out := append([]byte(nil), data...)
+	for _, m := range re.FindAllSubmatchIndex(data, -1) {
+		for i := m[0]; m[2] < 0 && i < m[1]; i++ {
+			if out[i] != '\n' {
+				out[i] = ' '
+			}
+		}
+	}
+	return out
+}
+
+func (c *Config) lock(name string, exclusive bool) (func(), error) {
+	if p, err := filepath.EvalSymlinks(name); err == nil {
+		name = p
+	}
+	c.mu.RLock()
+	timeout := c.timeout
+	c.mu.RUnlock()
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	unlock, err := flock(name+".lock", exclusive, timeout)
+	if err != nil {
+		return nil, &FileError{Op: "lock", Path: name, Err: err}
+	}
+	return unlock, nil
 }
 
 func (c *Config) readFile() (map[string]interface{}, error) {
 	vars := make(map[string]interface{})
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	modTime := c.configModified
-	if fi, err := stat(c.configFile); err == nil {
-		if modTime = fi.ModTime(); c.configModified.Equal(modTime) {
-			return vars, errNoChanges
+	c.mu.RLock()
+	name, modTime := c.configFile, c.configModified
+	c.mu.RUnlock()
+	if fi, err := stat(name); err == nil {
+		if modTime.Equal(fi.ModTime()) {
+			return vars, ErrNoChanges
 		}
+		modTime = fi.ModTime()
 	}
-	data, err := readfile(c.configFile)
+	unlock, err := c.lock(name, false)
 	if err != nil {
 		return vars, err
 	}
+	data, err := readfile(name)
+	unlock()
+	if err != nil {
+		return vars, &FileError{Op: "read", Path: name, Err: err}
+	}
+	c.mu.Lock()
 	c.configModified = modTime
-	err = json.Unmarshal(c.comment(data), &vars)
-	return vars, err
+	c.mu.Unlock()
+	data = c.comment(data)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	offset := int64(0)
+	if err = dec.Decode(&vars); err == nil {
+		offset = dec.InputOffset()
+		if _, e := dec.Token(); e != io.EOF {
+			err, offset = ErrTrailingData, int64(c.skip(data, int(offset)))
+		}
+	} else if e, ok := err.(*json.SyntaxError); ok && e.Offset > 0 {
+		offset = e.Offset - 1
+	} else if e, ok := err.(*json.UnmarshalTypeError); ok {
+		offset = e.Offset
+	} else {
+		offset = dec.InputOffset()
+	}
+	if err != nil {
+		line := bytes.Count(data[:offset], []byte("\n")) + 1
+		column := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+		return vars, &ParseError{Path: name, Line: line, Column: column, Err: err}
+	}
+	return vars, nil
 }
 
 func (c *Config) parseFiles(filenames ...string) (map[string]interface{}, error) {
 	vars := make(map[string]interface{})
+	c.mu.Lock()
+	c.configModified = time.Time{}
+	c.mu.Unlock()
 	for _, f := range filenames {
 		if filepath.IsAbs(f) {
 			c.mu.Lock()
@@ -288,6 +1109,8 @@ func (c *Config) parseFiles(filenames ...string) (map[string]interface{}, error)
 			c.mu.Unlock()
 			if vars, err := c.readFile(); err == nil {
 				return vars, nil
+			} else if pe := (*ParseError)(nil); errors.Is(err, ErrLockTimeout) || errors.As(err, &pe) {
+				return vars, err
 			}
 		} else {
 			for _, p := range paths {
@@ -296,20 +1119,41 @@ func (c *Config) parseFiles(filenames ...string) (map[string]interface{}, error)
 				c.mu.Unlock()
 				if vars, err := c.readFile(); err == nil {
 					return vars, nil
+				} else if pe := (*ParseError)(nil); errors.Is(err, ErrLockTimeout) || errors.As(err, &pe) {
+					return vars, err
 				}
 			}
 		}
 	}
+	name := filenames[0]
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(paths[len(paths)-1], name)
+	}
 	c.mu.Lock()
-	c.configFile = filepath.Join(paths[len(paths)-1], filenames[0])
+	c.configFile = name
+	first := c.first
 	c.mu.Unlock()
-	return vars, c.Save()
+	if first == FirstRunSkip {
+		return vars, nil
+	} else if first == FirstRunRequire {
+		return vars, &FileError{Op: "find", Path: name, Err: ErrNoFile}
+	}
+	return vars, c.write(true)
 }
 
-// Set the configuration target using this method.
+// Set the configuration target using this method.  A copy of the values it
+// holds is kept as the defaults used by SaveChanges, and the sources recorded
+// by a previous Load are discarded.
 func (c *Config) Target(t interface{}) {
 	c.mu.Lock()
-	c.target = t
+	c.target, c.defaults, c.origins = t, nil, nil
+	if v := reflect.ValueOf(t); v.Kind() == reflect.Ptr && !v.IsNil() {
+		if l, e := t.(locker); e {
+			l.Lock()
+			defer l.Unlock()
+		}
+		c.defaults = c.clone(v).Interface()
+	}
 	c.mu.Unlock()
 }
 
@@ -330,17 +1174,17 @@ func (c *Config) Target(t interface{}) {
 // another registered single-character command line option.
 func (c *Config) Add(name, description, env string, options ...string) error {
 	if name == "" {
-		return errEmptyName
+		return ErrEmptyName
 	} else if env == "" && len(options) == 0 {
-		return errNoEnvOptions
+		return ErrNoEnvOptions
 	} else if name == "." || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
-		return errBadNameSyntax
+		return ErrBadNameSyntax
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	for _, s := range c.settings {
 		if s.Name == name {
-			return errConflictingAdd
+			return ErrConflictingAdd
 		}
 	}
 	c.settings = append(c.settings, setting{
@@ -352,6 +1196,113 @@ func (c *Config) Add(name, description, env string, options ...string) error {
 	return nil
 }
 
+// As an alternative to Add, this will register settings from tags on the
+// target, using gonf for comma separated command line options, env for the
+// environment variable, and desc for the description:
+//
+//	Path string `gonf:"-p:,--path" env:"APP_PATH" desc:"path to run in"`
+//
+// A required or secret tag with a true value will also mark the setting as
+// required or secret, and a validate tag accepts a comma separated list of
+// rules for Validate (min=n, max=n, oneof=a|b, nonempty, file, and
+// regex=pattern last).
+// Names follow the same rules as json, including dot-notation for nested
+// structures and promotion of anonymous composite structures.  Any errors
+// from registration are aggregated and returned with the name of the field.
+func (c *Config) Scan() error {
+	c.mu.RLock()
+	t := c.target
+	c.mu.RUnlock()
+	if t == nil {
+		return ErrNilTarget
+	}
+	var errs []error
+	c.walk(reflect.TypeOf(t).Elem(), "", func(name string, f reflect.StructField) {
+		var options []string
+		if o := f.Tag.Get("gonf"); o != "" {
+			options = strings.Split(o, ",")
+		}
+		if env := f.Tag.Get("env"); env != "" || len(options) > 0 {
+			if err := c.Add(name, f.Tag.Get("desc"), env, options...); err != nil {
+				errs = append(errs, fmt.Errorf("failed to register %s, %w", name, err))
+			}
+			if r, _ := strconv.ParseBool(f.Tag.Get("required")); r {
+				c.Require(name)
+			}
+			if r, _ := strconv.ParseBool(f.Tag.Get("secret")); r {
+				c.Secret(name)
+			}
+		}
+		if rules, err := parseRules(f.Tag.Get("validate")); err != nil {
+			errs = append(errs, fmt.Errorf("failed to register %s, %w", name, err))
+		} else if len(rules) > 0 {
+			c.Validate(name, rules...)
+		}
+	})
+	return c.join(errs...)
+}
+
+// Marks registered settings as required, which will cause Load to return an
+// ErrMissingRequired error listing every required setting that was not
+// supplied by the file, environment variables, or command line options.  The
+// file created on first run leaves required settings out, so they remain
+// missing until supplied.  If any of the names have not been registered an
+// error is returned.
+func (c *Config) Require(names ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for _, n := range names {
+		found := false
+		for i := range c.settings {
+			if c.settings[i].Name == n {
+				c.settings[i].Required, found = true, true
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("failed to require %s, %w", n, ErrNotRegistered))
+		}
+	}
+	return c.join(errs...)
+}
+
+// Registers rules to validate the final value of any property by name, using
+// the same dot-notation as Add, after all inputs are merged by Load or Reload.
+//
+// Failures are returned as a ValidationError identifying the setting and the
+// source of its value, aggregated with any other errors, and the target is
+// left untouched when any rule fails.
+func (c *Config) Validate(name string, rules ...Rule) {
+	c.mu.Lock()
+	c.validations = append(c.validations, validation{name: name, rules: rules})
+	c.mu.Unlock()
+}
+
+// Marks registered settings as secret, so their values are rendered as ***
+// when the configuration is printed by Dump, --print-config, and
+// --explain-config, and are never written by Save, including the file written
+// on first run, which leaves any value already in the file untouched.  Names
+// beneath a slice of structures apply to every element.  Help never prints
+// the value of any setting.  If any of the names have not been registered an
+// error is returned.
+func (c *Config) Secret(names ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for _, n := range names {
+		found := false
+		for i := range c.settings {
+			if c.settings[i].Name == n {
+				c.settings[i].Secret, found = true, true
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("failed to mark %s secret, %w", n, ErrNotRegistered))
+		}
+	}
+	return c.join(errs...)
+}
+
 // To enable automated help, set a non-empty description.
 func (c *Config) Description(d string) {
 	c.mu.Lock()
@@ -359,6 +1310,102 @@ func (c *Config) Description(d string) {
 	c.mu.Unlock()
 }
 
+// Environment variables registered for slices are split using the separator,
+// which defaults to a comma.
+func (c *Config) Separator(s string) {
+	c.mu.Lock()
+	c.separator = s
+	c.mu.Unlock()
+}
+
+// Setting a non-empty prefix binds an environment variable to every property
+// of the target without registration, named by the prefix and the property in
+// upper snake case (eg. parent.childName becomes PREFIX_PARENT_CHILD_NAME).
+// Environment variables registered with Add take precedence.
+func (c *Config) EnvPrefix(p string) {
+	c.mu.Lock()
+	c.prefix = p
+	c.mu.Unlock()
+}
+
+// Sets what Load does when no file exists, which by default is to create one
+// with FirstRunCreate.  FirstRunSkip leaves the file system untouched, while
+// FirstRunRequire reports the missing file as a FileError wrapping ErrNoFile.
+// In every case the environment variables and command line options are still
+// applied, and the name of the file that would have been created is kept for
+// Save.
+func (c *Config) FirstRun(f FirstRun) {
+	c.mu.Lock()
+	c.first = f
+	c.mu.Unlock()
+}
+
+// Sets which values Save writes, using SaveChanges to skip values which are
+// unchanged from the defaults of the target, and SaveOmitInputs to skip values
+// supplied by environment variables or command line options.  Keys already in
+// an existing file are still updated by SaveChanges, so a value returned to
+// its default is not left behind, while keys skipped by SaveOmitInputs keep
+// the value already in the file.  SaveOmitInputs relies on the sources
+// recorded by Load, so it omits nothing until the target has been loaded.
+func (c *Config) SaveMode(m SaveMode) {
+	c.mu.Lock()
+	c.save = m
+	c.mu.Unlock()
+}
+
+// Sets how long Load, Reload and Save wait for the advisory lock shared by
+// every process using the same file, which defaults to 5 seconds.  Locking is
+// currently only supported on linux, where a lock file is created alongside
+// the configuration file by Save.
+func (c *Config) LockTimeout(d time.Duration) {
+	c.mu.Lock()
+	c.timeout = d
+	c.mu.Unlock()
+}
+
+// Sets the permissions used when Save creates a new file, which defaults to
+// 0644.  Use 0600 when the file may hold credentials.  Existing files keep
+// their current permissions.
+func (c *Config) FileMode(m os.FileMode) {
+	c.mu.Lock()
+	c.mode = m
+	c.mu.Unlock()
+}
+
+// When enabled, Reload will read environment variables again instead of
+// reusing the environment variables parsed by Load.
+func (c *Config) RefreshEnv(r bool) {
+	c.mu.Lock()
+	c.refresh = r
+	c.mu.Unlock()
+}
+
+// Registers post-processing hooks, which are run in order after each
+// successful Reload (including those performed by Watch or ReloadOnSignal)
+// with the list of settings that changed, so that only affected subsystems
+// need to be restarted.
+func (c *Config) OnReload(fn func([]Change)) {
+	if fn == nil {
+		return
+	}
+	c.mu.Lock()
+	c.hooks = append(c.hooks, fn)
+	c.mu.Unlock()
+}
+
+// Subscribes to changes of a single property by name, using the same
+// dot-notation as Add, which includes any properties beneath it.  After each
+// successful Reload that changes the value, and once the target has been
+// updated and unlocked, the function is called with the old and new values.
+func (c *Config) OnChange(name string, fn func(old, new interface{})) {
+	if fn == nil {
+		return
+	}
+	c.mu.Lock()
+	c.subscriptions = append(c.subscriptions, subscription{name: name, fn: fn})
+	c.mu.Unlock()
+}
+
 // Provides a registration for custom examples of command line use cases,
 // automatically prefixed by the application name.
 func (c *Config) Example(example string) {
@@ -375,89 +1422,293 @@ func (c *Config) Example(example string) {
 // and json file data onto the configuration target.
 //
 // A POSIX compatible getopt command line parser is run first to deal with
-// optional help flags and terminate prior to any file system access.
+// optional help flags and terminate prior to any file system access.  When a
+// description is set, the --explain-config flag will print every setting
+// with its value and source after loading, and the --print-config flag will
+// print the effective configuration, and then terminate.
 //
 // Custom paths may be supplied, both relative to the system paths or absolute
 // for full control.  Empty names will be discarded and ignored.  The default
 // name used is the application name as a directory then again as a .json file.
 // If no file is found, it uses the first name supplied (or the default) plus
 // the default userspace path (unless the file name is absolute) to save the
-// defaults on the configuration target.
+// defaults on the configuration target, unless FirstRun says otherwise.
 //
 // Data loaded from a file is applied directly and follows the same rules as
 // json unmarshal.  This means tags first, then property names, finally any
 // non-ambiguous properties matching anonynous composite structures.  File
 // configuration is generally useful when you have complex data structures
-// which cannot easily be represented using strings.
+// which cannot easily be represented using strings.  Durations are accepted
+// as strings (eg. 1m30s) or as integer nanoseconds, and time as RFC3339.
 //
 // While json does not provide support for comments, if // or /**/ comments
 // are found they will be safely filtered from the file (unless inside quotes).
 //
 // Both command line options and environment variables are converted to the
 // configuration targets expected types using reflection prior to being run
-// through json unmarshal.
+// through json unmarshal.  Repeated command line options accumulate when the
+// target is a slice, otherwise the last value is used, and strings such as
+// environment variables are split by the separator for slices.  Maps accept
+// key=value pairs the same way, and are merged with data from the file.
+// Types implementing encoding.TextUnmarshaler receive the raw string instead,
+// while those only implementing json.Unmarshaler receive it as a quoted json
+// string, and values that cannot be converted are skipped with an error
+// naming the setting.  Integers are parsed according to the
+// size of the target, so values that overflow, or negative values for
+// unsigned integers, are errors instead of being silently truncated.
+//
+// Any rules registered with Validate are run against the final values, and
+// settings marked as required that were not supplied by any of the file,
+// environment variables or command line options are reported as an error.
 //
 // If any steps fail, the errors will be collected and aggregated for the
 // response, however the system will still make a complete attempt to load
-// which means the errors may be treated as non-critical.
+// which means the errors may be treated as non-critical.  Values which cannot
+// be decoded onto the target are reported with an ApplyError while the rest
+// are still applied.  The exception is when the merged data fails
+// validation, in which case the target is left untouched.
 //
 // The operation is concurrently safe, and performs a lock prior to running
-// any steps that touch its own properties.  If the target supports mutex
-// locking it will lock while applying configuration.
+// any steps that touch its own properties.  The merged data is decoded into
+// a copy of the target and validated before the values are swapped onto the
+// target.  If the target supports mutex locking it will lock while copying
+// and while swapping, so readers never observe a mix of old and new values.
 //
 // Finally, it returns with an aggregate of any errors that were encountered
-// giving the developer the option of printing them or terminating.
+// giving the developer the option of printing them or terminating.  Multiple
+// errors are returned as Errors, and each may be inspected with errors.Is for
+// the exported sentinel errors, or with errors.As for a FileError, a
+// ParseError with the line and column of a malformed file, a ConversionError
+// naming the setting and source of a value, an ApplyError when the merged
+// inputs cannot be decoded onto the target, or a ValidationError.  A file
+// which exists but cannot be parsed is never replaced by the defaults.
 func (c *Config) Load(filenames ...string) error {
-	opts := c.parseOptions()
+	origins := []map[string]string{{}, {}, {}}
+	opts := c.parseOptions(origins[2])
 	for i := len(filenames) - 1; i >= 0; i-- {
 		if filenames[i] == "" {
 			filenames = append(filenames[:i], filenames[i+1:]...)
 		}
 	}
 	files, err := c.parseFiles(append(filenames, filepath.Join(appName, appName+".json"))...)
-	if e := c.to(files, c.parseEnvs(), opts); e != nil {
-		if err != nil {
-			return fmt.Errorf("%s\n%s", err.Error(), e.Error())
-		}
-		return e
+	envs := c.parseEnvs(origins[1])
+	c.flatten("", files, c.ConfigFile(), origins[0])
+	c.mu.Lock()
+	c.options, c.environment, c.origins = opts, envs, origins
+	explain, dump := c.explain, c.dump
+	c.mu.Unlock()
+	_, _, e := c.to(false, origins, files, envs, opts)
+	if explain {
+		c.explained(true)
 	}
-	return err
+	if dump {
+		c.printed(true)
+	}
+	return c.join(err, c.missing(files, envs, opts), e)
 }
 
 // Used to manually reload changes from the configuration file, if the file has
 // been modified since the last attempt to load it.
+//
+// The environment variables and command line options parsed by Load are
+// merged over the file again, preserving the same order of precedence.  If
+// RefreshEnv is enabled the environment variables are read again instead.
+//
+// Just like Load the data is decoded into a copy of the target and validated
+// before being applied, but any value which cannot be converted will also
+// leave the target untouched, so it never holds a partially applied file.
+//
+// After the target is updated, the settings that changed are identified by
+// comparing it before and after, and delivered to subscriptions registered
+// with OnChange, followed by hooks registered with OnReload.
 func (c *Config) Reload() error {
+	_, err := c.reload()
+	return err
+}
+
+func (c *Config) reload() ([]Change, error) {
 	if c.ConfigFile() == "" {
-		return errEmptyConfig
+		return nil, ErrEmptyConfig
 	}
 	v, err := c.readFile()
-	if err == nil && len(v) > 0 {
-		return c.to(v)
+	if err != nil || len(v) == 0 {
+		return nil, err
 	}
-	return err
+	origins := []map[string]string{{}, {}, {}}
+	c.flatten("", v, c.ConfigFile(), origins[0])
+	c.mu.RLock()
+	opts, envs, refresh := c.options, c.environment, c.refresh
+	if len(c.origins) == len(origins) {
+		origins[1], origins[2] = c.origins[1], c.origins[2]
+	}
+	c.mu.RUnlock()
+	if refresh {
+		origins[1] = map[string]string{}
+		envs = c.parseEnvs(origins[1])
+		c.mu.Lock()
+		c.environment = envs
+		c.mu.Unlock()
+	}
+	changes, fire, err := c.to(true, origins, v, envs, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.origins = origins
+	c.mu.Unlock()
+	for _, fn := range fire {
+		fn()
+	}
+	c.mu.RLock()
+	hooks := c.hooks
+	c.mu.RUnlock()
+	for _, fn := range hooks {
+		fn(changes)
+	}
+	return changes, nil
 }
 
 // For cases where you want to persist changes to the configuration target,
 // this function will save an intended readable json file to the ConfigFile
 // identified during Load, or it will return an error if any step fails.
+//
+// Durations are written as human readable strings (eg. 30s), while time is
+// written in RFC3339 format, both of which are accepted when loading.  Any
+// settings marked with Secret are omitted.
+//
+// When the file already exists, values are updated in place so comments,
+// key ordering, and keys unknown to the target are kept, while new keys are
+// appended.  A file which cannot be updated this way is left untouched and
+// ErrNotPatchable is returned, while an empty file is written from scratch.
+// New files include the description, environment variable, and command line
+// options of each registered setting as comments above its key.
+//
+// The file is written to a temporary file in the same directory, synced, and
+// renamed over the original, so a failure never leaves a truncated file.  On
+// unix the directory is synced as well, so the rename survives a crash.  The
+// mode of an existing file is preserved, along with its ownership where
+// permitted, while new files are created with the mode set by FileMode.
+// On linux an advisory lock is held while writing, which Load and Reload
+// respect, so multiple processes can safely share the same file.
 func (c *Config) Save() error {
+	return c.write(false)
+}
+
+func (c *Config) write(first bool) error {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	if c.configFile == "" {
-		return errEmptyConfig
+	name, mode := c.configFile, c.mode
+	c.mu.RUnlock()
+	if name == "" {
+		return ErrEmptyConfig
 	}
-	mkdirall(filepath.Dir(c.configFile), os.ModePerm)
-	f, err := create(c.configFile)
+	if p, err := filepath.EvalSymlinks(name); err == nil {
+		name = p
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+	dir := filepath.Dir(name)
+	if err := mkdirall(dir, os.ModePerm); err != nil {
+		return &FileError{Op: "create", Path: dir, Err: err}
+	}
+	unlock, err := c.lock(name, true)
 	if err != nil {
 		return err
 	}
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "\t")
-	if err := enc.Encode(c.target); err != nil {
-		f.Close()
+	defer unlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, err := c.export(c.target)
+	if err != nil {
 		return err
 	}
-	return f.Close()
+	c.redact(v, "", c.secrets(), true)
+	if first {
+		c.redact(v, "", c.required(), true)
+	}
+	if c.save&SaveOmitInputs != 0 {
+		c.inputs(v, "", reflect.TypeOf(c.target))
+	}
+	var defaults interface{}
+	if c.save&SaveChanges != 0 && c.defaults != nil {
+		if defaults, err = c.export(c.defaults); err != nil {
+			return err
+		}
+	}
+	var out []byte
+	if data, err := readfile(name); err == nil {
+		if out, err = c.rewrite(data, v, defaults); err != nil {
+			return &FileError{Op: "update", Path: name, Err: err}
+		}
+	}
+	if out == nil {
+		c.prune(v, defaults, reflect.TypeOf(c.target))
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		out = c.apply(b.Bytes(), c.annotate(b.Bytes(), 0, "", c.notes()))
+	}
+	f, err := tempfile(dir, "."+filepath.Base(name)+".")
+	if err != nil {
+		return &FileError{Op: "create", Path: name, Err: err}
+	}
+	defer os.Remove(f.Name())
+	if fi, err := stat(name); err == nil {
+		mode = fi.Mode().Perm()
+		owner(f, fi)
+	}
+	if _, err = f.Write(out); err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = rename(f.Name(), name)
+	}
+	if err == nil {
+		err = syncdir(dir)
+	}
+	if err != nil {
+		return &FileError{Op: "write", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Writes the effective configuration of the target, after merging the file,
+// environment variables and command line options, in the supplied format.
+// Currently json is the only supported format (and the default when empty).
+// Settings marked with Secret are rendered as ***.
+//
+// When a description is set, the --print-config flag will call this after
+// Load to print the configuration, and then terminate, exiting with 1 after
+// printing the error if it fails.
+func (c *Config) Dump(w io.Writer, format string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if format != "" && format != "json" {
+		return ErrBadFormat
+	} else if c.target == nil {
+		return ErrNilTarget
+	}
+	if l, e := c.target.(locker); e {
+		l.Lock()
+		defer l.Unlock()
+	}
+	v, err := c.export(c.target)
+	if err != nil {
+		return err
+	}
+	c.redact(v, "", c.secrets(), false)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
 }
 
 // If the instance has a non-empty Description the help will be printed,
@@ -466,6 +1717,16 @@ func (c *Config) Help() {
 	c.help(false)
 }
 
+// Returns the source of the value for a setting by name, using the same
+// dot-notation as Add, which is the path of the file, the name of the
+// environment variable, or the command line option and its argument index.
+// Settings which were not supplied by any input return "default".
+func (c *Config) Source(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.source(name, c.origins)
+}
+
 // After Load this will return the full path to the preferred file.
 func (c *Config) ConfigFile() string {
 	c.mu.RLock()
@@ -1,11 +1,14 @@
 package gonf
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -28,6 +31,29 @@ func (self *mockStat) Mode() os.FileMode  { return self.mode }
 func (self *mockStat) ModTime() time.Time { return self.modTime }
 func (self *mockStat) Sys() interface{}   { return &self.sys }
 
+type mockLevel int
+
+func (l *mockLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "debug":
+		*l = 1
+	case "info":
+		*l = 2
+	default:
+		return mockError
+	}
+	return nil
+}
+
+type mockRaw struct {
+	Data string
+}
+
+func (r *mockRaw) UnmarshalJSON(data []byte) error {
+	r.Data = string(data)
+	return nil
+}
+
 type Deeper struct {
 	TripleDepth string
 }
@@ -73,6 +99,23 @@ type mockConfig struct {
 
 	EnvOverrideFile   string
 	OptionOverrideEnv string
+
+	OptionSlice []string
+	EnvSlice    []int
+	Labels      map[string]string
+	Limits      map[string]int
+
+	Timeout  time.Duration
+	Interval time.Duration
+	Since    time.Time
+
+	ID    int64
+	Count uint64
+	Small int8
+
+	Address net.IP
+	Level   mockLevel
+	Raw     *mockRaw
 }
 
 var mockError error = errors.New("mock error")
@@ -127,6 +170,121 @@ func TestAdd(t *testing.T) {
 	}
 }
 
+type mockVerbose struct {
+	Verbose bool `gonf:"-v,--verbose"`
+}
+
+type mockTags struct {
+	Composite
+	mockVerbose
+	Path   string `gonf:"-p:,--path" env:"APP_PATH" desc:"path to run in" required:"true"`
+	Nested struct {
+		Name  string `json:"name" env:"APP_NESTED_NAME"`
+		Count int    `gonf:"--count" validate:"min=1,max=3"`
+	}
+	Ignored string `json:"-" env:"APP_IGNORED"`
+	Skipped string
+}
+
+func TestScan(t *testing.T) {
+	c := &Config{}
+	if c.Scan() != ErrNilTarget {
+		t.Error("failed to identify nil target...")
+	}
+	c.Target(&mockTags{})
+	if e := c.Scan(); e != nil || len(c.settings) != 4 {
+		t.Errorf("failed to register settings from tags: %v", e)
+	}
+	if c.settings[0].Name != "Verbose" || c.settings[1].Name != "Path" || c.settings[1].Env != "APP_PATH" ||
+		len(c.settings[1].Options) != 2 || !c.settings[1].Required || c.settings[0].Required || c.settings[2].Name != "Nested.name" || c.settings[3].Name != "Nested.Count" {
+		t.Error("failed to name settings registered from tags...")
+	}
+	if len(c.validations) != 1 || c.validations[0].name != "Nested.Count" || len(c.validations[0].rules) != 2 {
+		t.Error("failed to register validation rules from tags...")
+	}
+	if e := c.Scan(); !errors.Is(e, ErrConflictingAdd) || !strings.Contains(e.Error(), "Nested.Count") {
+		t.Error("failed to capture conflicting registrations from tags...")
+	}
+}
+
+func TestEnvPrefix(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	os.Args = []string{}
+	readfile = func(string) ([]byte, error) { return nil, mockError }
+	tempfile = func(string, string) (*os.File, error) { return nil, mockError }
+	mkdirall = func(string, os.FileMode) error { return nil }
+
+	c := &Config{}
+	mc := &mockConfig{}
+	c.Target(mc)
+	c.EnvPrefix("app")
+	c.Add("EnvString", "", "ENV_EXPLICIT")
+	c.Add("EnvByTag", "", "TAG_EXPLICIT")
+	os.Setenv("APP_ENV_STRING", "derived")
+	os.Setenv("TAG_EXPLICIT", "explicit")
+	os.Setenv("ENV_EXPLICIT", "explicit")
+	os.Setenv("APP_EXPLICIT_COMPOSITE_DEPTH_BY_OPTION", "3")
+	os.Setenv("APP_TRIPLE_DEPTH", "promoted")
+	os.Setenv("APP_ENV_BY_TAG", "derived")
+	os.Setenv("APP_OPTION_BY_TAG", "tagged")
+	os.Setenv("APP_ID", "12")
+	c.Load()
+	if mc.EnvString != "explicit" || mc.ExplicitComposite.DepthByOption != 3 || mc.TripleDepth != "promoted" || mc.OptionByTag != "tagged" || mc.ID != 12 {
+		t.Error("failed to bind environment variables by prefix...")
+	} else if mc.EnvByTag != "explicit" || c.Source("EnvByTag") != "TAG_EXPLICIT" {
+		t.Errorf("failed to prefer explicit environment variable for tagged field: %s", mc.EnvByTag)
+	}
+
+	var fmtPrintfData string
+	fmtPrintf = func(f string, a ...interface{}) (int, error) {
+		fmtPrintfData += fmt.Sprintf(f, a...)
+		return len(fmtPrintfData), nil
+	}
+	exit = func(int) {}
+	c.Description("test")
+	c.Help()
+	if !strings.Contains(fmtPrintfData, "APP_EXPLICIT_COMPOSITE_DEPTH_BY_ENV") || strings.Contains(fmtPrintfData, "APP_ENV_STRING") {
+		t.Error("failed to list derived environment variables in help...")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	c := &Config{}
+	c.Add("key", "", "KEY")
+	if c.Require("key") != nil || !c.settings[0].Required {
+		t.Error("failed to mark setting as required...")
+	}
+	if e := c.Require("key", "unregistered"); !errors.Is(e, ErrNotRegistered) || !strings.Contains(e.Error(), "unregistered") {
+		t.Error("failed to capture unregistered setting...")
+	}
+
+	// test required settings match case-insensitive keys and json tag names
+	c = &Config{}
+	c.Target(&mockConfig{})
+	c.Add("EnvByTag", "", "ENV_BY_TAG")
+	c.Add("ExplicitComposite.DepthByOption", "", "", "--depth")
+	c.Require("EnvByTag", "ExplicitComposite.DepthByOption")
+	if e := c.missing(map[string]interface{}{"envbytag": "a", "explicitcomposite": map[string]interface{}{"depthbyoption": 3}}); e != nil {
+		t.Errorf("failed to match case-insensitive keys: %v", e)
+	} else if e := c.missing(map[string]interface{}{"envByTag": "a"}, map[string]interface{}{"ExplicitComposite": map[string]interface{}{"DepthByOption": 3}}); e != nil {
+		t.Errorf("failed to match json tag names: %v", e)
+	} else if e := c.missing(map[string]interface{}{"ExplicitComposite": map[string]interface{}{}}); !errors.Is(e, ErrMissingRequired) {
+		t.Error("failed to identify missing settings...")
+	}
+}
+
+func TestSecret(t *testing.T) {
+	c := &Config{}
+	c.Add("key", "", "KEY")
+	if c.Secret("key") != nil || !c.settings[0].Secret {
+		t.Error("failed to mark setting as secret...")
+	}
+	if e := c.Secret("key", "unregistered"); !errors.Is(e, ErrNotRegistered) || !strings.Contains(e.Error(), "unregistered") {
+		t.Error("failed to capture unregistered setting...")
+	}
+}
+
 func TestDescription(_ *testing.T) {
 	c := &Config{}
 	c.Description("")
@@ -153,8 +311,6 @@ func TestLoad(t *testing.T) {
 	// set defaults for overrides
 	var fileStat = &mockStat{modTime: time.Now()}
 	var statError error
-	var createFile *os.File
-	var createError error
 	var readfileError error
 	var readfileData []byte
 	var exitCode int = 1
@@ -162,7 +318,7 @@ func TestLoad(t *testing.T) {
 
 	// define overrides
 	stat = func(_ string) (os.FileInfo, error) { return fileStat, statError }
-	create = func(string) (*os.File, error) { return createFile, createError }
+	tempfile = func(string, string) (*os.File, error) { return nil, mockError }
 	readfile = func(string) ([]byte, error) { return readfileData, readfileError }
 	mkdirall = func(string, os.FileMode) error { return nil }
 	exit = func(i int) { exitCode = i }
@@ -194,10 +350,17 @@ func TestLoad(t *testing.T) {
 	}
 	readfileError = nil
 
-	// test read file bad json
-	readfileData = []byte("this is not json")
-	if c.Load(cf) == nil {
-		t.Error("failed to capture json parse error...")
+	// test read file bad json reports the position including comments
+	var pe *ParseError
+	readfileData = []byte("{\n\t// comment\n\t\"a\": 1,,\n}")
+	if e := c.Load(cf); !errors.As(e, &pe) || pe.Line != 3 || pe.Column != 9 || pe.Path != cf {
+		t.Errorf("failed to capture json parse error: %v", e)
+	}
+
+	// test read file with trailing data
+	readfileData = []byte(`{} trailing`)
+	if _, e := c.readFile(); !errors.Is(e, ErrTrailingData) || !errors.As(e, &pe) || pe.Line != 1 || pe.Column != 4 {
+		t.Errorf("failed to capture trailing data error: %v", e)
 	}
 
 	// test read file with file comments and unregistered tags using absolute path
@@ -299,6 +462,137 @@ func TestLoad(t *testing.T) {
 		t.Error("failed to properly order input overrides...")
 	}
 
+	// test repeated options and separated environment variables as slices
+	c.Add("OptionSlice", "", "", "-t:", "--tag")
+	c.Add("EnvSlice", "", "ENV_SLICE")
+	c.Separator(";")
+	os.Args = []string{"-t", "a", "--tag=b", "-tc"}
+	os.Setenv("ENV_SLICE", "1;2;3")
+	if c.Load(cf) != nil || len(mc.OptionSlice) != 3 || mc.OptionSlice[2] != "c" || len(mc.EnvSlice) != 3 || mc.EnvSlice[2] != 3 {
+		t.Error("failed to accumulate repeated options or split environment variables into slices...")
+	}
+	os.Args = []string{"--tag", "a;b", "--tag", "c"}
+	if c.Load(cf) != nil || len(mc.OptionSlice) != 2 || mc.OptionSlice[0] != "a;b" {
+		t.Error("failed to keep separators in command line options...")
+	}
+	os.Args = []string{"--tag", "a;b"}
+	if c.Load(cf) != nil || len(mc.OptionSlice) != 1 || mc.OptionSlice[0] != "a;b" {
+		t.Error("failed to keep separators in a single command line option...")
+	}
+	os.Args = []string{"--option-override=five", "--option-override=six"}
+	if c.Load(cf) != nil || mc.OptionOverrideEnv != "six" {
+		t.Error("failed to use the last repeated option for a non-slice...")
+	}
+	c.Separator("")
+	os.Unsetenv("ENV_SLICE")
+
+	// test byte slices decode the last option as base64
+	bc := &Config{}
+	bt := &struct{ Key []byte }{}
+	bc.Target(bt)
+	bc.Add("Key", "", "", "--key")
+	os.Args = []string{"--key", "d29ybGQ=", "--key", "aGVsbG8="}
+	if e := bc.Load(cf); e != nil || string(bt.Key) != "hello" {
+		t.Errorf("failed to decode byte slice option: %v", e)
+	}
+
+	// test key=value pairs from options and environment merged with file maps
+	readfileData = []byte(`{"Labels": {"file": "kept", "env": "replaced"}}`)
+	c.Add("Labels", "", "", "--label")
+	c.Add("Limits", "", "ENV_LIMITS")
+	os.Args = []string{"--label", "env=prod", "--label", "team=core"}
+	os.Setenv("ENV_LIMITS", "cpu=2,memory=512")
+	if c.Load(cf) != nil || len(mc.Labels) != 3 || mc.Labels["file"] != "kept" || mc.Labels["env"] != "prod" ||
+		mc.Labels["team"] != "core" || mc.Limits["cpu"] != 2 || mc.Limits["memory"] != 512 {
+		t.Error("failed to merge maps from file, options, and environment variables...")
+	}
+	os.Unsetenv("ENV_LIMITS")
+
+	// test durations and time from file, options, and environment variables
+	readfileData = []byte(`{"Interval": "1m30s"}`)
+	c.Add("Timeout", "", "", "--timeout")
+	c.Add("Since", "", "ENV_SINCE")
+	os.Args = []string{"--timeout", "30s"}
+	os.Setenv("ENV_SINCE", "2016-01-02T15:04:05Z")
+	if c.Load(cf) != nil || mc.Timeout != 30*time.Second || mc.Interval != 90*time.Second || mc.Since.Year() != 2016 {
+		t.Error("failed to parse durations or time...")
+	}
+	os.Unsetenv("ENV_SINCE")
+
+	// test text and json unmarshalers with errors naming the setting
+	c.Add("Address", "", "ENV_ADDRESS")
+	c.Add("Level", "", "", "--level")
+	c.Add("Raw", "", "ENV_RAW")
+	os.Args = []string{"--level", "debug"}
+	os.Setenv("ENV_ADDRESS", "10.0.0.1")
+	os.Setenv("ENV_RAW", "unquoted")
+	if c.Load(cf) != nil || !mc.Address.Equal(net.ParseIP("10.0.0.1")) || mc.Level != 1 || mc.Raw == nil || mc.Raw.Data != `"unquoted"` {
+		t.Error("failed to hand raw strings to unmarshalers...")
+	}
+	os.Setenv("ENV_RAW", "123")
+	if c.Load(cf) != nil || mc.Raw == nil || mc.Raw.Data != `"123"` {
+		t.Errorf("failed to quote json values for unmarshalers: %v", mc.Raw)
+	}
+	os.Args = []string{"--level", "verbose"}
+	os.Setenv("ENV_ADDRESS", "10.0.0.2")
+	if e := c.Load(cf); e == nil || !strings.Contains(e.Error(), "Level") || mc.Level != 1 || !mc.Address.Equal(net.ParseIP("10.0.0.2")) {
+		t.Error("failed to capture unmarshaler error by setting name...")
+	}
+	os.Unsetenv("ENV_ADDRESS")
+	os.Unsetenv("ENV_RAW")
+
+	// test lossless integers from file, options, and environment variables
+	readfileData = []byte(`{"Count": 18446744073709551615}`)
+	c.Add("ID", "", "", "--id")
+	c.Add("Small", "", "ENV_SMALL")
+	os.Args = []string{"--id", "9007199254740993"}
+	os.Setenv("ENV_SMALL", "-128")
+	if c.Load(cf) != nil || mc.ID != 9007199254740993 || mc.Count != 18446744073709551615 || mc.Small != -128 {
+		t.Error("failed to convert integers without loss...")
+	}
+	os.Setenv("ENV_SMALL", "300")
+	if e := c.Load(cf); e == nil || !strings.Contains(e.Error(), "overflows") || mc.Small != -128 {
+		t.Error("failed to capture integer overflow...")
+	}
+	os.Unsetenv("ENV_SMALL")
+	c.Add("Count", "", "", "--count")
+	os.Args = []string{"--count=-1"}
+	if e := c.Load(cf); e == nil || !strings.Contains(e.Error(), "negative") {
+		t.Error("failed to capture negative unsigned integer...")
+	}
+	os.Args = []string{}
+
+	// test required settings missing from every input
+	readfileData = []byte(`{"FileUnregisteredProperty": "file"}`)
+	c.Require("EnvString", "OptionString", "ExplicitComposite.Deeper.TripleDepth")
+	os.Args = []string{}
+	os.Unsetenv("ENV_DUPLICATE")
+	os.Unsetenv("ENV_TRIPLE")
+	if e := c.Load(cf); e == nil || !strings.Contains(e.Error(), "OptionString, EnvString, ExplicitComposite.Deeper.TripleDepth") {
+		t.Errorf("failed to list missing required settings: %v", e)
+	}
+	readfileData = []byte(`{"OptionString": "file"}`)
+	os.Setenv("ENV_DUPLICATE", "12")
+	os.Setenv("ENV_TRIPLE", "depth")
+	if e := c.Load(cf); e != nil {
+		t.Errorf("failed to accept required settings from file and environment variables: %v", e)
+	}
+	for i := range c.settings {
+		c.settings[i].Required = false
+	}
+
+	// test validation of merged values by source while still applying the target
+	os.Setenv("ENV_DUPLICATE", "30")
+	c.Validate("EnvNumber", Min(1), Max(20))
+	c.Validate("ExplicitComposite.DepthByOption", Min(0))
+	c.Validate("Missing.Property", NonEmpty())
+	var v *ValidationError
+	if e := c.Load(cf); !errors.As(e, &v) || v.Setting != "EnvNumber" || v.Source != "ENV_DUPLICATE" || mc.EnvNumber == 30 || !strings.Contains(e.Error(), "Missing.Property") {
+		t.Errorf("failed to validate merged values: %v", e)
+	}
+	c.validations = nil
+	os.Unsetenv("ENV_DUPLICATE")
+
 	// test help without description
 	exitCode = 1
 	os.Args = []string{"--help"}
@@ -330,6 +624,148 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+func TestSource(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	var fmtPrintfData string
+	var exitCode int = 1
+	fmtPrintf = func(f string, a ...interface{}) (int, error) {
+		fmtPrintfData += fmt.Sprintf(f, a...)
+		return len(fmtPrintfData), nil
+	}
+	exit = func(i int) { exitCode = i }
+	stat = func(string) (os.FileInfo, error) { return nil, mockError }
+	readfile = func(string) ([]byte, error) {
+		return []byte(`{"Labels": {"file": "a"}, "ExplicitComposite": {"DepthByOption": 3}, "Timeout": "1s"}`), nil
+	}
+
+	c := &Config{}
+	mc := &mockConfig{}
+	c.Target(mc)
+	c.Add("EnvString", "", "ENV_STRING")
+	c.Add("OptionBool", "", "", "-b")
+	c.Add("OptionString", "", "", "--option")
+	os.Setenv("ENV_STRING", "env")
+	os.Args = []string{"app", "-b", "--option", "value", "--explain-config"}
+	c.Load("/tmp/gonf.json")
+	for name, source := range map[string]string{
+		"EnvString":                       "ENV_STRING",
+		"OptionBool":                      "-b (argument 1)",
+		"OptionString":                    "--option (argument 2)",
+		"Labels":                          "/tmp/gonf.json",
+		"Labels.file":                     "/tmp/gonf.json",
+		"ExplicitComposite":               "/tmp/gonf.json",
+		"ExplicitComposite.DepthByOption": "/tmp/gonf.json",
+		"ExplicitComposite.DepthByEnv":    "default",
+		"EnvNumber":                       "default",
+	} {
+		if s := c.Source(name); s != source {
+			t.Errorf("failed to identify source of %s: %s", name, s)
+		}
+	}
+
+	// test settings which fail conversion keep their previous source
+	c.Add("EnvNumber", "", "ENV_NUMBER")
+	os.Setenv("ENV_NUMBER", "nan")
+	if e := c.Load("/tmp/gonf.json"); e == nil {
+		t.Error("failed to reject invalid number...")
+	} else if s := c.Source("EnvNumber"); s != "default" {
+		t.Errorf("failed to discard source of rejected value: %s", s)
+	}
+	os.Unsetenv("ENV_NUMBER")
+
+	// test explain without description
+	if fmtPrintfData != "" || exitCode != 1 {
+		t.Error("failed to ignore explain without description...")
+	}
+
+	// test explain with description
+	c.Description("test explain")
+	c.Load("/tmp/gonf.json")
+	if exitCode != 0 || !strings.Contains(fmtPrintfData, `"value" (--option (argument 2))`) ||
+		!strings.Contains(fmtPrintfData, `"1s" (/tmp/gonf.json)`) || !strings.Contains(fmtPrintfData, `false (default)`) {
+		t.Errorf("failed to explain configuration: %s", fmtPrintfData)
+	}
+}
+
+func TestDump(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	var fmtPrintfData string
+	var exitCode int = 1
+	fmtPrintf = func(f string, a ...interface{}) (int, error) {
+		fmtPrintfData += fmt.Sprintf(f, a...)
+		return len(fmtPrintfData), nil
+	}
+	exit = func(i int) { exitCode = i }
+	stat = func(string) (os.FileInfo, error) { return nil, mockError }
+	readfile = func(string) ([]byte, error) {
+		return []byte(`{"envByTag": "file", "ExplicitComposite": {"DepthByOption": 3}, "Timeout": "1s"}`), nil
+	}
+
+	// test nil target and bad format
+	c := &Config{}
+	var b bytes.Buffer
+	if c.Dump(&b, "") != ErrNilTarget {
+		t.Error("failed to capture nil target...")
+	}
+	c.Description("test print")
+	exitCode = 0
+	if c.printed(true); exitCode != 1 || !strings.Contains(fmtPrintfData, ErrNilTarget.Error()) {
+		t.Errorf("failed to report print error: %s", fmtPrintfData)
+	}
+	c.Description("")
+	fmtPrintfData, exitCode = "", 1
+	c.Target(&mockConfig{})
+	if c.Dump(&b, "yaml") != ErrBadFormat {
+		t.Error("failed to reject unsupported format...")
+	}
+
+	// test redaction by field name and nested path
+	c.Add("EnvByTag", "", "ENV_BY_TAG")
+	c.Add("ExplicitComposite.DepthByOption", "", "", "--depth")
+	c.Add("OptionString", "", "", "--option")
+	c.Secret("EnvByTag", "ExplicitComposite.DepthByOption")
+	os.Setenv("ENV_BY_TAG", "password")
+	os.Args = []string{"app", "--option", "value", "--print-config"}
+	c.Load("/tmp/gonf.json")
+	if fmtPrintfData != "" || exitCode != 1 {
+		t.Error("failed to ignore print without description...")
+	}
+	if c.Dump(&b, "json") != nil || strings.Contains(b.String(), "password") || !strings.Contains(b.String(), `"envByTag": "***"`) ||
+		!strings.Contains(b.String(), `"DepthByOption": "***"`) || !strings.Contains(b.String(), `"OptionString": "value"`) ||
+		!strings.Contains(b.String(), `"Timeout": "1s"`) {
+		t.Errorf("failed to dump redacted configuration: %s", b.String())
+	}
+
+	// test print and explain with description
+	c.Description("test print")
+	c.Load("/tmp/gonf.json")
+	if exitCode != 0 || fmtPrintfData != b.String() {
+		t.Errorf("failed to print configuration: %s", fmtPrintfData)
+	}
+	fmtPrintfData, exitCode = "", 1
+	os.Args = []string{"app", "--explain-config"}
+	c.Load("/tmp/gonf.json")
+	if exitCode != 0 || strings.Contains(fmtPrintfData, "password") || !strings.Contains(fmtPrintfData, `"***" (ENV_BY_TAG)`) {
+		t.Errorf("failed to redact explained configuration: %s", fmtPrintfData)
+	}
+
+	// test redaction of secrets nested in slices of structures
+	type server struct {
+		Host     string
+		Password string
+	}
+	c = &Config{}
+	c.Target(&struct{ Servers []server }{[]server{{"a", "password"}, {"b", "password"}}})
+	c.Add("Servers.Password", "", "SERVER_PASSWORD")
+	c.Secret("Servers.Password")
+	b.Reset()
+	if c.Dump(&b, "json") != nil || strings.Contains(b.String(), "password") || strings.Count(b.String(), `"Password": "***"`) != 2 {
+		t.Errorf("failed to redact secrets in slices: %s", b.String())
+	}
+}
+
 func TestReload(t *testing.T) {
 	c := &Config{}
 
@@ -377,6 +813,144 @@ func TestReload(t *testing.T) {
 	if e := c.Reload(); e != nil {
 		t.Error("failed to successfully parse, %s\n", e)
 	}
+
+	// test conversion failure leaves the target untouched
+	mc := &mockConfig{EnvNumber: 5}
+	c.Target(mc)
+	c.configModified = time.Time{}
+	readfileData = []byte(`{"EnvNumber": "bad", "EnvString": "changed"}`)
+	if e := c.Reload(); e == nil || mc.EnvNumber != 5 || mc.EnvString != "" {
+		t.Errorf("failed to leave target untouched after conversion error: %v", e)
+	}
+
+	// test decode failure leaves the target untouched
+	c.configModified = time.Time{}
+	readfileData = []byte(`{"EnvString": "changed", "Labels": ["not", "a", "map"]}`)
+	if e := c.Reload(); e == nil || mc.EnvString != "" {
+		t.Errorf("failed to leave target untouched after decode error: %v", e)
+	}
+
+	// test validation failure leaves the target untouched
+	c.Validate("EnvNumber", Max(10))
+	c.configModified = time.Time{}
+	readfileData = []byte(`{"EnvNumber": 20, "EnvString": "changed"}`)
+	var v *ValidationError
+	if e := c.Reload(); !errors.As(e, &v) || v.Setting != "EnvNumber" || v.Source != "test.gonf.json" || mc.EnvNumber != 5 || mc.EnvString != "" {
+		t.Errorf("failed to leave target untouched after validation error: %v", e)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	stat = os.Stat
+	f, e := ioutil.TempFile(os.TempDir(), "gonf")
+	if e != nil {
+		t.Error("failed to acquire temporary file...")
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	for i, r := range []struct {
+		rule  Rule
+		value interface{}
+		valid bool
+	}{
+		{Min(2), 2, true},
+		{Min(2), uint(1), false},
+		{Min(2), "a", false},
+		{Max(2), 2.5, false},
+		{Max(2), []string{"a", "b"}, true},
+		{OneOf("a", "b"), "b", true},
+		{OneOf("a", "b"), "c", false},
+		{Match("^[a-z]+$"), "abc", true},
+		{Match("^[a-z]+$"), "ABC", false},
+		{NonEmpty(), "", false},
+		{NonEmpty(), map[string]string{"a": "b"}, true},
+		{NonEmpty(), 0, false},
+		{NonEmpty(), nil, false},
+		{NonEmpty(), true, true},
+		{FileExists(), "/not/found", false},
+		{FileExists(), os.TempDir(), false},
+		{FileExists(), f.Name(), true},
+	} {
+		if e := r.rule(r.value); (e == nil) != r.valid {
+			t.Errorf("failed rule %d with %v: %v", i, r.value, e)
+		}
+	}
+
+	if r, e := parseRules("min=1,max=5,oneof=1|2,nonempty,file,regex=^[0-9]{1,3}$"); e != nil || len(r) != 6 || r[5]("123") != nil || r[5]("1234") == nil {
+		t.Errorf("failed to parse rules: %v", e)
+	}
+	for _, tag := range []string{"min=a", "max=", "regex=[", "unknown"} {
+		if _, e := parseRules(tag); e == nil {
+			t.Errorf("failed to capture bad rule %s...", tag)
+		}
+	}
+}
+
+func TestReloadPrecedence(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	var readfileData []byte = []byte(`{"EnvOverrideFile": "file", "OptionOverrideEnv": "file", "FileUnregisteredProperty": "file"}`)
+	stat = func(string) (os.FileInfo, error) { return nil, mockError }
+	readfile = func(string) ([]byte, error) { return readfileData, nil }
+
+	c := &Config{}
+	mc := &mockConfig{}
+	c.Target(mc)
+	c.Add("EnvOverrideFile", "", "ENV_OVERRIDE_FILE")
+	c.Add("OptionOverrideEnv", "", "ENV_OVERRIDDEN", "--option-override")
+	os.Args = []string{"--option-override=option"}
+	os.Setenv("ENV_OVERRIDE_FILE", "env")
+	os.Setenv("ENV_OVERRIDDEN", "env")
+	if e := c.Load("/tmp/gonf.json"); e != nil || mc.EnvOverrideFile != "env" || mc.OptionOverrideEnv != "option" {
+		t.Errorf("failed to load with precedence: %v", e)
+	}
+
+	// test reload keeps environment and option precedence over the file
+	var changes []Change
+	c.OnReload(func(c []Change) { changes = c })
+	os.Args = []string{}
+	os.Setenv("ENV_OVERRIDE_FILE", "changed")
+	readfileData = []byte(`{"EnvOverrideFile": "reload", "OptionOverrideEnv": "reload", "FileUnregisteredProperty": "reload"}`)
+	if e := c.Reload(); e != nil || mc.EnvOverrideFile != "env" || mc.OptionOverrideEnv != "option" || mc.FileUnregisteredProperty != "reload" {
+		t.Errorf("failed to preserve precedence on reload: %v", e)
+	}
+	if len(changes) != 1 || changes[0].Name != "FileUnregisteredProperty" || changes[0].Old != "file" || changes[0].New != "reload" {
+		t.Errorf("failed to identify changes on reload: %v", changes)
+	}
+
+	// test sources of values are preserved on reload
+	if c.Source("FileUnregisteredProperty") != "/tmp/gonf.json" || c.Source("EnvOverrideFile") != "ENV_OVERRIDE_FILE" ||
+		c.Source("OptionOverrideEnv") != "--option-override (argument 0)" || c.Source("EnvString") != "default" {
+		t.Error("failed to identify sources after reload...")
+	}
+
+	// test reload with refreshed environment variables and subscriptions
+	var subscribed []string
+	c.OnChange("EnvOverrideFile", nil)
+	c.OnChange("EnvOverrideFile", func(o, n interface{}) { subscribed = append(subscribed, o.(string)+" "+n.(string)) })
+	c.OnChange("ExplicitComposite", func(o, n interface{}) {
+		subscribed = append(subscribed, o.(Composite).TripleDepth+" "+n.(Composite).TripleDepth)
+	})
+	c.OnChange("OptionOverrideEnv", func(o, n interface{}) { subscribed = append(subscribed, "unchanged") })
+	c.RefreshEnv(true)
+	readfileData = []byte(`{"ExplicitComposite": {"TripleDepth": "deep"}}`)
+	if e := c.Reload(); e != nil || mc.EnvOverrideFile != "changed" || mc.OptionOverrideEnv != "option" {
+		t.Errorf("failed to refresh environment variables on reload: %v", e)
+	}
+	if len(changes) != 2 || changes[0].Name != "ExplicitComposite.TripleDepth" || changes[1].Name != "EnvOverrideFile" || changes[1].New != "changed" {
+		t.Errorf("failed to identify nested changes on reload: %v", changes)
+	}
+	if len(subscribed) != 2 || subscribed[0] != "env changed" || subscribed[1] != " deep" {
+		t.Errorf("failed to notify subscriptions: %v", subscribed)
+	}
+
+	// test changes hold copies rather than the values on the target
+	readfileData = []byte(`{"ExplicitComposite": {"TripleDepth": "deep"}, "Labels": {"a": "b"}}`)
+	if e := c.Reload(); e != nil || len(changes) != 1 || changes[0].Name != "Labels" {
+		t.Errorf("failed to identify map change on reload: %v", changes)
+	} else if changes[0].New.(map[string]string)["a"] = "changed"; mc.Labels["a"] != "b" {
+		t.Error("failed to copy changed values...")
+	}
 }
 
 func TestSave(t *testing.T) {
@@ -385,11 +959,29 @@ func TestSave(t *testing.T) {
 		t.Error("failed to acquire temporary directory...")
 	}
 	cf := filepath.Join(d, "gonf.json")
-	defer os.Remove(cf)
+	defer os.RemoveAll(d)
 
-	var createError error
-	var createFile *os.File
-	create = func(string) (*os.File, error) { return createFile, createError }
+	var mkdirallError, tempfileError, renameError error
+	stat = os.Stat
+	readfile = ioutil.ReadFile
+	mkdirall = func(p string, m os.FileMode) error {
+		if mkdirallError != nil {
+			return mkdirallError
+		}
+		return os.MkdirAll(p, m)
+	}
+	tempfile = func(dir, pattern string) (*os.File, error) {
+		if tempfileError != nil {
+			return nil, tempfileError
+		}
+		return ioutil.TempFile(dir, pattern)
+	}
+	rename = func(from, to string) error {
+		if renameError != nil {
+			return renameError
+		}
+		return os.Rename(from, to)
+	}
 
 	c := &Config{}
 
@@ -398,22 +990,230 @@ func TestSave(t *testing.T) {
 		t.Error("failed to identify empty configuration file name...")
 	}
 
-	// test bypass create error but nil target (eg. fail json encode?)
+	// test mkdirall error behavior
 	c.configFile = cf
-	if c.Save() == nil {
-		t.Error("failed to capture encoder error...")
+	mkdirallError = mockError
+	if e := c.Save(); e == nil || !strings.Contains(e.Error(), mockError.Error()) {
+		t.Error("failed to capture mkdirall error...")
 	}
+	mkdirallError = nil
 
 	// test create error behavior
-	createError = mockError
+	tempfileError = mockError
 	if c.Save() == nil {
 		t.Error("failed to capture create error...")
 	}
+	tempfileError = nil
+
+	// test with (valid) nil target and mode of new files
+	c.FileMode(0600)
+	if c.Save() != nil {
+		t.Error("failed to save with nil target...")
+	} else if fi, err := os.Stat(cf); err != nil || fi.Mode().Perm() != 0600 {
+		t.Error("failed to apply mode to new file...")
+	}
+
+	// test human readable durations and time, and preserved mode
+	os.Chmod(cf, 0640)
+	c.Target(&mockConfig{Timeout: 30 * time.Second, Since: time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)})
+	if c.Save() != nil {
+		t.Error("failed to save durations and time...")
+	} else if data, _ := ioutil.ReadFile(cf); !strings.Contains(string(data), `"Timeout": "30s"`) || !strings.Contains(string(data), `"Since": "2016-01-02T15:04:05Z"`) {
+		t.Errorf("failed to save human readable durations and time: %s", data)
+	} else if fi, err := os.Stat(cf); err != nil || fi.Mode().Perm() != 0640 {
+		t.Error("failed to preserve mode of existing file...")
+	}
+
+	// test rename error leaves the original intact without temporary files
+	renameError = mockError
+	c.Target(&mockConfig{})
+	if c.Save() == nil {
+		t.Error("failed to capture rename error...")
+	} else if data, _ := ioutil.ReadFile(cf); !strings.Contains(string(data), `"Timeout": "30s"`) {
+		t.Errorf("failed to preserve original file: %s", data)
+	} else if files, _ := filepath.Glob(filepath.Join(d, ".gonf.json.*")); len(files) != 0 {
+		t.Error("failed to remove temporary file...")
+	}
+	renameError = nil
+
+	// test an existing file that cannot be patched is left untouched
+	original, _ := ioutil.ReadFile(cf)
+	ioutil.WriteFile(cf, []byte(`["not", "an", "object"]`), 0644)
+	if e := c.Save(); !errors.Is(e, ErrNotPatchable) {
+		t.Errorf("failed to reject unpatchable file: %v", e)
+	} else if data, _ := ioutil.ReadFile(cf); string(data) != `["not", "an", "object"]` {
+		t.Errorf("failed to preserve unpatchable file: %s", data)
+	}
+	ioutil.WriteFile(cf, original, 0644)
+
+	// test secrets are omitted
+	c.Target(&mockConfig{EnvByTag: "password", ExplicitComposite: Composite{DepthByOption: 3}})
+	c.Add("EnvByTag", "", "ENV_BY_TAG")
+	c.Add("ExplicitComposite.DepthByOption", "", "", "--depth")
+	c.Secret("EnvByTag", "ExplicitComposite.DepthByOption")
+	if c.Save() != nil {
+		t.Error("failed to save with secrets...")
+	} else if data, _ := ioutil.ReadFile(cf); strings.Contains(string(data), "password") || !strings.Contains(string(data), `"envByTag": ""`) ||
+		strings.Contains(string(data), `"DepthByOption": 3`) || !strings.Contains(string(data), `"DepthByOption": 0`) {
+		t.Errorf("failed to omit secrets from saved file: %s", data)
+	}
+
+	// test new files describe registered settings
+	os.Remove(cf)
+	c.Add("OptionString", "an option", "", "--option")
+	if c.Save() != nil {
+		t.Error("failed to save new file...")
+	} else if data, _ := ioutil.ReadFile(cf); !strings.Contains(string(data), "\t// an option\n\t// flags: --option\n\t\"OptionString\": \"\"") {
+		t.Errorf("failed to describe settings in new file: %s", data)
+	}
+
+	// test comments and unknown keys are preserved
+	ioutil.WriteFile(cf, []byte(commentedFileData), 0644)
+	c.Target(&mockConfig{FileUnregisteredProperty: "updated"})
+	if c.Save() != nil {
+		t.Error("failed to save over commented file...")
+	} else if data, _ := ioutil.ReadFile(cf); !strings.HasPrefix(string(data), "{\n\t// this is a comment\n") ||
+		!strings.Contains(string(data), `"FileUnregisteredProperty": "updated"`) || !strings.Contains(string(data), "also a\n\tcomment*/") ||
+		!strings.Contains(string(data), `"Timeout": "0s"`) {
+		t.Errorf("failed to preserve comments in saved file: %s", data)
+	}
+}
+
+func TestSaveMode(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	d, e := ioutil.TempDir(os.TempDir(), "gonf")
+	if e != nil {
+		t.Error("failed to acquire temporary directory...")
+	}
+	cf := filepath.Join(d, "gonf.json")
+	defer os.RemoveAll(d)
+	stat, readfile, mkdirall, tempfile, rename = os.Stat, ioutil.ReadFile, os.MkdirAll, ioutil.TempFile, os.Rename
+
+	c := &Config{}
+	mc := &mockConfig{OptionString: "default", Labels: map[string]string{"a": "b"}}
+	c.Target(mc)
+	c.Add("EnvString", "", "ENV_STRING")
+	c.Add("OptionBool", "", "", "-b")
+	c.SaveMode(SaveChanges | SaveOmitInputs)
+	os.Setenv("ENV_STRING", "env")
+	os.Args = []string{"app", "-b"}
+
+	// test new files have no defaults or inputs
+	ioutil.WriteFile(cf, []byte(`{}`), 0644)
+	if c.Load(cf) != nil || mc.EnvString != "env" || !mc.OptionBool {
+		t.Error("failed to load with save mode...")
+	} else if os.Remove(cf); c.Save() != nil {
+		t.Error("failed to save new file...")
+	} else if data, _ := ioutil.ReadFile(cf); string(data) != "{}\n" {
+		t.Errorf("failed to omit defaults and inputs: %s", data)
+	}
+
+	// test only changed values are added
+	mc.ExplicitComposite.DepthByOption = 3
+	mc.Labels["c"] = "d"
+	if c.Save() != nil {
+		t.Error("failed to save changes...")
+	} else if data, _ := ioutil.ReadFile(cf); string(data) != "{\n\t\"ExplicitComposite\": {\n\t\t\"DepthByOption\": 3\n\t},\n\t\"Labels\": {\n\t\t\"a\": \"b\",\n\t\t\"c\": \"d\"\n\t}\n}\n" {
+		t.Errorf("failed to save only changes: %s", data)
+	}
+
+	// test existing keys are updated when returned to defaults
+	mc.ExplicitComposite.DepthByOption = 0
+	if c.Save() != nil {
+		t.Error("failed to save changes...")
+	} else if data, _ := ioutil.ReadFile(cf); !strings.Contains(string(data), `"DepthByOption": 0`) {
+		t.Errorf("failed to update existing key: %s", data)
+	}
+
+	// test inputs are written without SaveOmitInputs
+	c.SaveMode(SaveChanges)
+	if c.Save() != nil {
+		t.Error("failed to save inputs...")
+	} else if data, _ := ioutil.ReadFile(cf); !strings.Contains(string(data), `"EnvString": "env"`) || !strings.Contains(string(data), `"OptionBool": true`) || strings.Contains(string(data), "OptionString") {
+		t.Errorf("failed to save inputs: %s", data)
+	}
 
-	// test with (valid) nil target
-	createFile, createError = os.Create(cf)
+	// test a new target discards the sources of the previous load
+	c.Target(&mockConfig{EnvString: "retargeted"})
+	c.SaveMode(SaveOmitInputs)
 	if c.Save() != nil {
-		t.Error("failed to open temporary file for success scenarior...")
+		t.Error("failed to save new target...")
+	} else if data, _ := ioutil.ReadFile(cf); !strings.Contains(string(data), `"EnvString": "retargeted"`) {
+		t.Errorf("failed to discard sources of previous target: %s", data)
+	}
+}
+
+func TestFirstRun(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	d, e := ioutil.TempDir(os.TempDir(), "gonf")
+	if e != nil {
+		t.Error("failed to acquire temporary directory...")
+	}
+	cf := filepath.Join(d, "gonf.json")
+	defer os.RemoveAll(d)
+	stat, readfile, mkdirall, tempfile, rename = os.Stat, ioutil.ReadFile, os.MkdirAll, ioutil.TempFile, os.Rename
+	os.Args = []string{"app", "-b"}
+
+	c := &Config{}
+	mc := &struct {
+		OptionBool bool
+		Labels     map[string]string
+	}{}
+	c.Target(mc)
+	c.Add("OptionBool", "", "", "-b")
+
+	// test skip leaves the file system untouched
+	c.FirstRun(FirstRunSkip)
+	if c.Load(cf) != nil || !mc.OptionBool || c.ConfigFile() != cf {
+		t.Error("failed to skip missing file...")
+	} else if _, err := os.Stat(cf); err == nil {
+		t.Error("failed to skip creating file...")
+	}
+
+	// test require reports the missing file
+	c.FirstRun(FirstRunRequire)
+	if e := c.Load(cf); !errors.Is(e, ErrNoFile) || !strings.Contains(e.Error(), cf) || !mc.OptionBool {
+		t.Error("failed to report missing file...")
+	} else if _, err := os.Stat(cf); err == nil {
+		t.Error("failed to skip creating file...")
+	}
+
+	// test create saves the absolute path, and repeated loads find it
+	c.FirstRun(FirstRunCreate)
+	if c.Load(cf) != nil {
+		t.Error("failed to create missing file...")
+	} else if _, err := os.Stat(cf); err != nil {
+		t.Error("failed to create file at absolute path...")
+	}
+	c.FirstRun(FirstRunRequire)
+	if c.Load(cf) != nil {
+		t.Error("failed to find unchanged file...")
+	}
+
+	// test null values in an existing file are accepted
+	ioutil.WriteFile(cf, []byte(`{"Labels": null}`), 0644)
+	if e := c.Load(cf); e != nil || mc.Labels != nil {
+		t.Errorf("failed to load null values: %v", e)
+	}
+
+	// test required settings are left out of the file created on first run
+	rf := filepath.Join(d, "required.json")
+	os.Args = []string{"app"}
+	c = &Config{}
+	c.Target(&struct {
+		Token string
+		Name  string
+	}{})
+	c.Add("Token", "", "TOKEN")
+	c.Require("Token")
+	if e := c.Load(rf); !errors.Is(e, ErrMissingRequired) {
+		t.Errorf("failed to report missing required setting: %v", e)
+	} else if data, _ := ioutil.ReadFile(rf); strings.Contains(string(data), "Token") || !strings.Contains(string(data), `"Name": ""`) {
+		t.Errorf("failed to omit required setting from new file: %s", data)
+	} else if e := c.Load(rf); !errors.Is(e, ErrMissingRequired) {
+		t.Errorf("failed to report missing required setting on second load: %v", e)
 	}
 }
 
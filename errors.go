@@ -0,0 +1,125 @@
+package gonf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors, which may be compared with errors.Is against any error
+// returned by this package.
+var (
+	ErrNilTarget       = errors.New("no configuration target supplied...")
+	ErrEmptyConfig     = errors.New("no configuration file...")
+	ErrNoChanges       = errors.New("the configuration file has not changed...")
+	ErrEmptyName       = errors.New("name cannot be empty...")
+	ErrNoEnvOptions    = errors.New("environment variable must not be empty or at least one command line option is expected...")
+	ErrBadNameSyntax   = errors.New("bad syntax for child properties...")
+	ErrConflictingAdd  = errors.New("duplicate option detected...")
+	ErrTrailingData    = errors.New("unexpected data after the configuration...")
+	ErrNotRegistered   = errors.New("setting has not been registered...")
+	ErrNotFound        = errors.New("setting was not found on the target...")
+	ErrBadFormat       = errors.New("unsupported format...")
+	ErrLockTimeout     = errors.New("timed out waiting for the file lock...")
+	ErrNoFile          = errors.New("no configuration file exists...")
+	ErrNotSupported    = errors.New("file notifications are not supported...")
+	ErrMissingRequired = errors.New("required setting was not supplied...")
+	ErrNotPatchable    = errors.New("unable to update the existing configuration in place...")
+)
+
+// A collection of errors, which remains compatible with errors.Is and
+// errors.As by exposing each of the errors it contains.
+type Errors []error
+
+func (e Errors) Error() string {
+	msg := make([]string, len(e))
+	for i, err := range e {
+		msg[i] = err.Error()
+	}
+	return strings.Join(msg, "\n")
+}
+
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Identifies the file and the operation on it which failed, such as read,
+// lock, create, update, write, or find when no file exists.
+type FileError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return "failed to " + e.Op + " " + e.Path + ", " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Identifies the position in a file which could not be parsed, where lines
+// and columns count from 1 and include any comments.
+type ParseError struct {
+	Path   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse %s at line %d, column %d, %s", e.Path, e.Line, e.Column, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Identifies the setting and the source of a value which could not be
+// converted to the type of the target.
+type ConversionError struct {
+	Setting string
+	Source  string
+	Err     error
+}
+
+func (e *ConversionError) Error() string {
+	if e.Source == "" {
+		return "failed to convert " + e.Setting + ", " + e.Err.Error()
+	}
+	return "failed to convert " + e.Setting + " from " + e.Source + ", " + e.Err.Error()
+}
+
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// Wraps the error from decoding the merged inputs onto the target, in which
+// case the target is left untouched.
+type ApplyError struct {
+	Err error
+}
+
+func (e *ApplyError) Error() string {
+	return "failed to apply configuration, " + e.Err.Error()
+}
+
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// Identifies the setting and the source of the value which failed a Rule.
+type ValidationError struct {
+	Setting string
+	Source  string
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid " + e.Setting + " from " + e.Source + ", " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,117 @@
+package gonf
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrors(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	stat = func(string) (os.FileInfo, error) { return nil, mockError }
+	readfile = func(string) ([]byte, error) { return []byte(`{"Count": "-1"}`), nil }
+
+	c := &Config{}
+	mc := &mockConfig{}
+	c.Target(mc)
+	c.Add("EnvNumber", "", "ENV_NUMBER")
+	c.Add("Small", "", "", "--small")
+	c.Add("EnvString", "", "ENV_STRING")
+	c.Require("EnvString")
+	os.Setenv("ENV_NUMBER", "nan")
+	os.Args = []string{"app", "--small", "300"}
+
+	// test conversion errors identify the setting and source
+	e := c.Load("/tmp/gonf.json")
+	var all Errors
+	if !errors.As(e, &all) || len(all) != 4 {
+		t.Fatalf("failed to aggregate errors: %v", e)
+	}
+	for setting, source := range map[string]string{"Count": "/tmp/gonf.json", "EnvNumber": "ENV_NUMBER", "Small": "--small (argument 1)"} {
+		found := false
+		for _, err := range all {
+			var ce *ConversionError
+			found = found || errors.As(err, &ce) && ce.Setting == setting && ce.Source == source
+		}
+		if !found {
+			t.Errorf("failed to identify conversion of %s from %s: %v", setting, source, e)
+		}
+	}
+	if !errors.Is(e, ErrMissingRequired) || !strings.Contains(e.Error(), "failed to convert Small from --small (argument 1), 300 overflows int8") {
+		t.Errorf("failed to describe errors: %v", e)
+	}
+
+	// test file errors identify the operation and path
+	var fe *FileError
+	readfile = func(string) ([]byte, error) { return nil, mockError }
+	c.FirstRun(FirstRunRequire)
+	os.Args = []string{"app"}
+	os.Setenv("ENV_STRING", "set")
+	os.Unsetenv("ENV_NUMBER")
+	if e := c.Load("/tmp/gonf.json"); !errors.As(e, &fe) || fe.Op != "find" || fe.Path != "/tmp/gonf.json" || !errors.Is(e, ErrNoFile) {
+		t.Errorf("failed to identify missing file: %v", e)
+	}
+	if _, e := c.readFile(); !errors.As(e, &fe) || fe.Op != "read" || !errors.Is(e, mockError) {
+		t.Errorf("failed to identify read error: %v", e)
+	}
+}
+
+func TestMalformedFile(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+	d, e := ioutil.TempDir(os.TempDir(), "gonf")
+	if e != nil {
+		t.Fatal("failed to acquire temporary directory...")
+	}
+	defer os.RemoveAll(d)
+	cf := filepath.Join(d, "gonf.json")
+	stat, readfile, mkdirall, tempfile, rename = os.Stat, ioutil.ReadFile, os.MkdirAll, ioutil.TempFile, os.Rename
+	os.Args = []string{"app"}
+
+	// test comments are blanked so positions match the original file
+	data := "{\n\t/* a\n\tcomment */ \"a\": 1 // b\n}"
+	if b := string((&Config{}).comment([]byte(data))); len(b) != len(data) || strings.Count(b, "\n") != 3 || strings.Contains(b, "comment") {
+		t.Errorf("failed to blank comments in place: %q", b)
+	}
+
+	// test a malformed existing file is reported and left untouched
+	malformed := "{\n\t// keep this\n\t\"EnvString\": \n}\n"
+	ioutil.WriteFile(cf, []byte(malformed), 0644)
+	c := &Config{}
+	c.Target(&mockConfig{})
+	var pe *ParseError
+	if e := c.Load(cf); !errors.As(e, &pe) || pe.Line != 4 {
+		t.Errorf("failed to report malformed file: %v", e)
+	} else if b, _ := ioutil.ReadFile(cf); string(b) != malformed {
+		t.Errorf("failed to leave malformed file untouched: %s", b)
+	}
+
+	// test values which cannot be decoded onto the target are typed
+	var ae *ApplyError
+	ioutil.WriteFile(cf, []byte(`{"OptionNumber": {"a": 1}}`), 0644)
+	if e := c.Load(cf); !errors.As(e, &ae) {
+		t.Errorf("failed to type apply error: %v", e)
+	}
+
+	// test load still applies the values which could be decoded
+	mc := &mockConfig{}
+	c.Target(mc)
+	c.Add("OptionString", "", "", "--option")
+	os.Args = []string{"app", "--option"}
+	ioutil.WriteFile(cf, []byte(`{"EnvString": "file", "OptionNumber": 3}`), 0644)
+	if e := c.Load(cf); !errors.As(e, &ae) || mc.EnvString != "file" || mc.OptionNumber != 3 {
+		t.Errorf("failed to apply decodable values alongside apply error: %v", e)
+	}
+
+	// test reload leaves the target untouched when values cannot be decoded
+	ioutil.WriteFile(cf, []byte(`{"EnvString": "reload", "OptionNumber": 4}`), 0644)
+	os.Chtimes(cf, time.Now(), time.Now().Add(time.Minute))
+	if e := c.Reload(); !errors.As(e, &ae) || mc.EnvString != "file" || mc.OptionNumber != 3 {
+		t.Errorf("failed to leave target untouched on reload: %v", e)
+	}
+}
@@ -1,10 +1,7 @@
 package gonf_test
 
 import (
-	"os"
-	"os/signal"
-	"runtime"
-	"syscall"
+	"context"
 
 	"github.com/cdelorme/gonf"
 )
@@ -26,19 +23,6 @@ func (a *Signal) Run() {
 	// run the applications logic
 }
 
-func (s *Signal) sighup(c *gonf.Config) {
-	if runtime.GOOS == "windows" {
-		return
-	}
-	h := make(chan os.Signal)
-	signal.Notify(h, syscall.SIGHUP)
-	for _ = range h {
-		if c.Reload() == nil {
-			s.PostProcessing()
-		}
-	}
-}
-
 func Example_signal() {
 	app := &Signal{Path: "/tmp/default"}
 
@@ -55,6 +39,10 @@ func Example_signal() {
 
 	c.Load()
 	app.PostProcessing()
-	go app.sighup(c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.OnReload(func([]gonf.Change) { app.PostProcessing() })
+	c.ReloadOnSignal(ctx)
 	app.Run()
 }
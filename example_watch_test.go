@@ -0,0 +1,47 @@
+package gonf_test
+
+import (
+	"context"
+
+	"github.com/cdelorme/gonf"
+)
+
+type Watching struct {
+	Path    string
+	Skip    bool
+	HowMany int `json:"number,omitempty"`
+}
+
+func (w *Watching) PostProcessing() {
+	// fix sensitive inputs
+	// generate computed fields
+	// clear effected caches
+	// safely restart dependent services
+}
+
+func (w *Watching) Run() {
+	// run the applications logic
+}
+
+func Example_watch() {
+	app := &Watching{Path: "/tmp/default"}
+
+	c := &gonf.Config{}
+	c.Target(app)
+	c.Description("An example application with watched reloads")
+
+	c.Add("Path", "Path to run operations in", "APP_PATH", "-p:", "--path")
+	c.Add("Skip", "a skippable boolean (false is default)", "APP_SKIP", "-s", "--skip")
+	c.Add("number", "number of cycles", "APP_NUMBER", "-n:", "--number")
+
+	c.Example("-p ~/ -sn 3")
+	c.Example("--path=~/ --number=3")
+
+	c.Load()
+	app.PostProcessing()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Watch(ctx, gonf.WatchOptions{OnChange: func([]gonf.Change) { app.PostProcessing() }})
+	app.Run()
+}
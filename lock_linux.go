@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package gonf
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func flock(name string, exclusive bool, timeout time.Duration) (func(), error) {
+	flag, how := os.O_RDONLY, syscall.LOCK_SH
+	if exclusive {
+		flag, how = os.O_RDWR|os.O_CREATE, syscall.LOCK_EX
+	}
+	f, err := os.OpenFile(name, flag, 0644)
+	if os.IsNotExist(err) && !exclusive {
+		return func() {}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return func() {
+				syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+				f.Close()
+			}, nil
+		} else if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			f.Close()
+			return nil, err
+		} else if time.Now().After(deadline) {
+			f.Close()
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
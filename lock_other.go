@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package gonf
+
+import "time"
+
+func flock(_ string, _ bool, _ time.Duration) (func(), error) {
+	return func() {}, nil
+}
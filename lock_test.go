@@ -0,0 +1,70 @@
+package gonf
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("file locking is only supported on linux...")
+	}
+	stat = os.Stat
+	readfile = ioutil.ReadFile
+	mkdirall = os.MkdirAll
+	tempfile = ioutil.TempFile
+	rename = os.Rename
+	d, e := ioutil.TempDir(os.TempDir(), "gonf")
+	if e != nil {
+		t.Fatal("failed to acquire temporary directory...")
+	}
+	defer os.RemoveAll(d)
+	cf := filepath.Join(d, "gonf.json")
+
+	c := &Config{}
+	c.configFile = cf
+	c.LockTimeout(50 * time.Millisecond)
+
+	// test shared lock without a lock file
+	if unlock, err := c.lock(cf, false); err != nil {
+		t.Error("failed to skip missing lock file...")
+	} else if unlock(); func() bool { _, err := os.Stat(cf + ".lock"); return err == nil }() {
+		t.Error("failed to avoid creating lock file for readers...")
+	}
+
+	// test exclusive lock blocks readers and writers until released
+	unlock, err := c.lock(cf, true)
+	if err != nil {
+		t.Fatalf("failed to acquire exclusive lock: %s", err)
+	}
+	if _, err := c.lock(cf, false); !errors.Is(err, ErrLockTimeout) {
+		t.Error("failed to time out shared lock...")
+	}
+	if e := c.Save(); !errors.Is(e, ErrLockTimeout) {
+		t.Error("failed to time out save...")
+	}
+	if _, err := c.parseFiles(cf); !errors.Is(err, ErrLockTimeout) {
+		t.Error("failed to stop searching files when locked...")
+	}
+	unlock()
+
+	// test shared locks do not block each other
+	if c.Save() != nil {
+		t.Error("failed to save once unlocked...")
+	}
+	first, err := c.lock(cf, false)
+	if err != nil {
+		t.Fatalf("failed to acquire shared lock: %s", err)
+	}
+	if second, err := c.lock(cf, false); err != nil {
+		t.Error("failed to share lock...")
+	} else {
+		second()
+	}
+	first()
+}
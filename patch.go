@@ -0,0 +1,327 @@
+package gonf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+type member struct {
+	key                string
+	start, vstart, end int
+}
+
+type edit struct {
+	start, end int
+	text       string
+}
+
+func (c *Config) skip(data []byte, i int) int {
+	for i < len(data) {
+		if data[i] == ' ' || data[i] == '\t' || data[i] == '\r' || data[i] == '\n' {
+			i++
+		} else if data[i] == '#' || bytes.HasPrefix(data[i:], []byte("//")) {
+			if n := bytes.IndexByte(data[i:], '\n'); n >= 0 {
+				i += n + 1
+			} else {
+				i = len(data)
+			}
+		} else if bytes.HasPrefix(data[i:], []byte("/*")) {
+			if n := bytes.Index(data[i+2:], []byte("*/")); n >= 0 {
+				i += n + 4
+			} else {
+				i = len(data)
+			}
+		} else {
+			break
+		}
+	}
+	return i
+}
+
+func (c *Config) span(data []byte, i int) int {
+	if i >= len(data) {
+		return -1
+	} else if data[i] == '"' {
+		for j := i + 1; j < len(data); j++ {
+			if data[j] == '\\' {
+				j++
+			} else if data[j] == '"' {
+				return j + 1
+			}
+		}
+		return -1
+	} else if data[i] == '{' || data[i] == '[' {
+		for j, depth := i+1, 1; j < len(data); {
+			if j = c.skip(data, j); j >= len(data) {
+				break
+			} else if data[j] == '"' {
+				if j = c.span(data, j); j < 0 {
+					return -1
+				}
+			} else if data[j] == '{' || data[j] == '[' {
+				depth, j = depth+1, j+1
+			} else if data[j] == '}' || data[j] == ']' {
+				if depth, j = depth-1, j+1; depth == 0 {
+					return j
+				}
+			} else {
+				j++
+			}
+		}
+		return -1
+	}
+	j := i
+	for j < len(data) && !strings.ContainsRune(",}] \t\r\n/#", rune(data[j])) {
+		j++
+	}
+	if j == i {
+		return -1
+	}
+	return j
+}
+
+func (c *Config) members(data []byte, i int) ([]member, int, bool) {
+	var members []member
+	if i >= len(data) || data[i] != '{' {
+		return nil, 0, false
+	}
+	j := c.skip(data, i+1)
+	for j < len(data) && data[j] != '}' {
+		m := member{start: j}
+		e := c.span(data, j)
+		if data[j] != '"' || e < 0 || json.Unmarshal(data[j:e], &m.key) != nil {
+			return nil, 0, false
+		}
+		if j = c.skip(data, e); j >= len(data) || data[j] != ':' {
+			return nil, 0, false
+		}
+		m.vstart = c.skip(data, j+1)
+		if m.end = c.span(data, m.vstart); m.end < 0 {
+			return nil, 0, false
+		}
+		members = append(members, m)
+		if j = c.skip(data, m.end); j < len(data) && data[j] == ',' {
+			j = c.skip(data, j+1)
+		} else if j >= len(data) || data[j] != '}' {
+			return nil, 0, false
+		}
+	}
+	return members, j, j < len(data)
+}
+
+func (c *Config) indentation(data []byte, i int, fallback string) string {
+	j := i
+	for j > 0 && (data[j-1] == ' ' || data[j-1] == '\t') {
+		j--
+	}
+	if j == 0 || data[j-1] == '\n' {
+		return string(data[j:i])
+	}
+	return fallback
+}
+
+func (c *Config) patch(data []byte, i int, indent string, t reflect.Type, v, d map[string]interface{}) ([]edit, bool) {
+	members, end, ok := c.members(data, i)
+	if !ok {
+		return nil, false
+	}
+	var edits []edit
+	seen := map[string]bool{}
+	in, unit := indent+"\t", "\t"
+	if len(members) > 0 {
+		if n := c.indentation(data, members[0].start, in); len(n) > len(indent) && strings.HasPrefix(n, indent) {
+			in, unit = n, n[len(indent):]
+		}
+	}
+	for _, m := range members {
+		k := m.key
+		if _, ok := v[k]; !ok {
+			for n := range v {
+				if strings.EqualFold(n, k) && !seen[n] {
+					k = n
+				}
+			}
+		}
+		if _, ok := v[k]; !ok || seen[k] {
+			continue
+		}
+		seen[k] = true
+		in = c.indentation(data, m.start, indent+unit)
+		ft := c.element(t, k)
+		if sub, ok := v[k].(map[string]interface{}); ok && data[m.vstart] == '{' && ft != nil && ft.Kind() == reflect.Struct {
+			dm, _ := d[k].(map[string]interface{})
+			e, ok := c.patch(data, m.vstart, in, ft, sub, dm)
+			if !ok {
+				return nil, false
+			}
+			edits = append(edits, e...)
+			continue
+		}
+		var old interface{}
+		dec := json.NewDecoder(bytes.NewReader(c.comment(data[m.vstart:m.end])))
+		dec.UseNumber()
+		if dec.Decode(&old) == nil && (reflect.DeepEqual(old, v[k]) || ft == durationType && c.equal(old, v[k])) {
+			continue
+		}
+		b, err := json.MarshalIndent(v[k], in, unit)
+		if err != nil {
+			return nil, false
+		}
+		edits = append(edits, edit{m.vstart, m.end, string(b)})
+	}
+
+	var keys []string
+	for k := range v {
+		if x, ok := d[k]; ok && reflect.DeepEqual(v[k], x) {
+			continue
+		} else if !seen[k] {
+			c.prune(v[k], x, c.element(t, k))
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return edits, true
+	}
+	sort.Strings(keys)
+	var text []string
+	for _, k := range keys {
+		n, _ := json.Marshal(k)
+		b, err := json.MarshalIndent(v[k], in, unit)
+		if err != nil {
+			return nil, false
+		}
+		text = append(text, "\n"+in+string(n)+": "+string(b))
+	}
+	if len(members) == 0 {
+		return append(edits, edit{i + 1, i + 1, strings.Join(text, ",") + "\n" + indent}), true
+	}
+	last := members[len(members)-1].end
+	for last < end && (data[last] == ' ' || data[last] == '\t') {
+		last++
+	}
+	if data[last] == '#' || bytes.HasPrefix(data[last:], []byte("//")) {
+		last += bytes.IndexByte(data[last:], '\n')
+	} else {
+		last = members[len(members)-1].end
+	}
+	return append(edits, edit{members[len(members)-1].end, members[len(members)-1].end, ","}, edit{last, last, strings.Join(text, ",")}), true
+}
+
+func (c *Config) equal(a, b interface{}) bool {
+	x, e1 := time.ParseDuration(fmt.Sprint(a))
+	y, e2 := time.ParseDuration(fmt.Sprint(b))
+	return e1 == nil && e2 == nil && x == y
+}
+
+func (c *Config) element(t reflect.Type, k string) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || c.isCustom(t) {
+		return nil
+	}
+	f, ok := c.field(reflect.New(t).Elem(), k)
+	if !ok {
+		return nil
+	}
+	t = f.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if c.isCustom(t) {
+		return nil
+	}
+	return t
+}
+
+func (c *Config) rewrite(data []byte, v, defaults interface{}) ([]byte, error) {
+	i := c.skip(data, 0)
+	if i >= len(data) || bytes.HasPrefix(data[i:], []byte("null")) && c.skip(data, i+4) >= len(data) {
+		return nil, nil
+	}
+	m, ok := v.(map[string]interface{})
+	d, _ := defaults.(map[string]interface{})
+	if !ok || c.target == nil || data[i] != '{' {
+		return nil, ErrNotPatchable
+	}
+	edits, ok := c.patch(data, i, c.indentation(data, i, ""), reflect.TypeOf(c.target), m, d)
+	if !ok {
+		return nil, ErrNotPatchable
+	}
+	return c.apply(data, edits), nil
+}
+
+func (c *Config) apply(data []byte, edits []edit) []byte {
+	sort.SliceStable(edits, func(a, b int) bool { return edits[a].start < edits[b].start })
+	var b bytes.Buffer
+	p := 0
+	for _, e := range edits {
+		b.Write(data[p:e.start])
+		b.WriteString(e.text)
+		p = e.end
+	}
+	b.Write(data[p:])
+	return b.Bytes()
+}
+
+func (c *Config) notes() map[string][]string {
+	settings := map[string]setting{}
+	for _, s := range c.settings {
+		settings[c.canonical(s.Name)] = s
+	}
+	for _, d := range c.derived() {
+		if s, ok := settings[c.canonical(d.Name)]; ok && s.Env == "" {
+			s.Env = d.Env
+			settings[c.canonical(d.Name)] = s
+		} else if !ok {
+			settings[c.canonical(d.Name)] = setting{Name: d.Name, Env: d.Env}
+		}
+	}
+	notes := map[string][]string{}
+	for name, s := range settings {
+		var lines, inputs []string
+		d := s.Description
+		if s.Required {
+			d = strings.TrimSpace(d + " (required)")
+		}
+		if d != "" {
+			lines = append(lines, strings.Split(d, "\n")...)
+		}
+		if s.Env != "" {
+			inputs = append(inputs, "env: "+s.Env)
+		}
+		if len(s.Options) > 0 {
+			inputs = append(inputs, "flags: "+strings.Replace(strings.Join(s.Options, ", "), ":", "", -1))
+		}
+		if len(inputs) > 0 {
+			lines = append(lines, strings.Join(inputs, ", "))
+		}
+		if len(lines) > 0 {
+			notes[name] = lines
+		}
+	}
+	return notes
+}
+
+func (c *Config) annotate(data []byte, i int, prefix string, notes map[string][]string) []edit {
+	members, _, ok := c.members(data, i)
+	if !ok {
+		return nil
+	}
+	var edits []edit
+	for _, m := range members {
+		indent := c.indentation(data, m.start, "")
+		if lines, ok := notes[prefix+m.key]; ok {
+			edits = append(edits, edit{m.start, m.start, "// " + strings.Join(lines, "\n"+indent+"// ") + "\n" + indent})
+		}
+		if data[m.vstart] == '{' {
+			edits = append(edits, c.annotate(data, m.vstart, prefix+m.key+".", notes)...)
+		}
+	}
+	return edits
+}
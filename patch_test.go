@@ -0,0 +1,86 @@
+package gonf
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRewrite(t *testing.T) {
+	type nested struct {
+		Depth int
+		Name  string
+	}
+	type target struct {
+		Name    string
+		Timeout time.Duration
+		Nested  nested
+		Labels  map[string]string
+		Added   bool `json:"added"`
+	}
+	c := &Config{}
+
+	// test unsupported documents and nil targets
+	if _, err := c.rewrite([]byte(`{}`), map[string]interface{}{}, nil); err != ErrNotPatchable {
+		t.Error("failed to reject nil target...")
+	}
+	c.Target(&target{Name: "new", Timeout: time.Minute, Nested: nested{Depth: 4}, Labels: map[string]string{"a": "b"}})
+	v, _ := c.export(c.target)
+	for _, data := range []string{`[]`, `{"Name": }`, `{"Name": "old"`, `{"Name" "old"}`, `{"Name": "old" "Timeout": "1s"}`} {
+		if _, err := c.rewrite([]byte(data), v, nil); err != ErrNotPatchable {
+			t.Errorf("failed to reject malformed document: %s", data)
+		}
+	}
+
+	// test empty documents are left to be encoded from scratch
+	for _, data := range []string{``, " \n", "// only a comment\n", "null\n"} {
+		if out, err := c.rewrite([]byte(data), v, nil); out != nil || err != nil {
+			t.Errorf("failed to skip empty document: %q", data)
+		}
+	}
+
+	// test comments, ordering, unknown keys, and indentation are preserved
+	for data, expect := range map[string]string{
+		`{}`: "{\n\t\"Labels\": {\n\t\t\"a\": \"b\"\n\t},\n\t\"Name\": \"new\",\n\t\"Nested\": {\n\t\t\"Depth\": 4,\n\t\t\"Name\": \"\"\n\t},\n\t\"Timeout\": \"1m0s\",\n\t\"added\": false\n}",
+		"# leading\n{\n  // the name\n  \"name\": \"old\", # trailing\n  \"unknown\": [1, {\"x\": \"}\"}],\n  /* nested */\n  \"Nested\": {\n    \"Depth\": 3, // depth\n    \"Name\": \"\"\n  },\n  \"Labels\": {\"c\": \"d\"},\n  \"Timeout\": \"60s\" // last\n}\n": "# leading\n{\n  // the name\n  \"name\": \"new\", # trailing\n  \"unknown\": [1, {\"x\": \"}\"}],\n  /* nested */\n  \"Nested\": {\n    \"Depth\": 4, // depth\n    \"Name\": \"\"\n  },\n  \"Labels\": {\n    \"a\": \"b\"\n  },\n  \"Timeout\": \"60s\", // last\n  \"added\": false\n}\n",
+	} {
+		if out, err := c.rewrite([]byte(data), v, nil); err != nil || string(out) != expect {
+			t.Errorf("failed to rewrite document in place: %s", out)
+		}
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	type nested struct {
+		Depth int
+	}
+	type target struct {
+		Name   string `json:"name"`
+		Nested nested
+		Quiet  bool
+	}
+	c := &Config{}
+	c.Target(&target{})
+	c.EnvPrefix("app")
+	c.Add("Name", "name of the\nservice", "", "-n:", "--name")
+	c.Add("Nested.Depth", "", "DEPTH")
+	c.Require("Name")
+
+	// test comments above registered, nested, and derived settings
+	data := []byte("{\n\t\"Nested\": {\n\t\t\"Depth\": 0\n\t},\n\t\"Quiet\": false,\n\t\"name\": \"\"\n}\n")
+	expect := "{\n\t\"Nested\": {\n\t\t// env: DEPTH\n\t\t\"Depth\": 0\n\t},\n\t// env: APP_QUIET\n\t\"Quiet\": false,\n\t// name of the\n\t// service (required)\n\t// env: APP_NAME, flags: -n, --name\n\t\"name\": \"\"\n}\n"
+	out := c.apply(data, c.annotate(data, 0, "", c.notes()))
+	var v map[string]interface{}
+	if string(out) != expect || json.Unmarshal(c.comment(out), &v) != nil {
+		t.Errorf("failed to annotate document: %s", out)
+	}
+
+	// test documents which are not objects are untouched
+	if out := c.apply([]byte("null\n"), c.annotate([]byte("null\n"), 0, "", c.notes())); string(out) != "null\n" {
+		t.Errorf("failed to ignore non-object document: %s", out)
+	}
+	if strings.Contains(string(c.apply(data, c.annotate(data, 0, "", nil))), "//") {
+		t.Error("failed to skip settings without notes...")
+	}
+}
@@ -0,0 +1,148 @@
+package gonf
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Rule validates the final value of a setting after all inputs have been
+// merged, returning an error describing why the value is invalid.  Custom
+// rules may be supplied as any function with the same signature.
+type Rule func(interface{}) error
+
+func size(v interface{}) (float64, bool) {
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(r.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(r.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return r.Float(), true
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return float64(r.Len()), true
+	}
+	return 0, false
+}
+
+// Min expects numbers, or the length of strings, slices and maps, to be no
+// less than n.
+func Min(n float64) Rule {
+	return func(v interface{}) error {
+		if s, ok := size(v); ok && s < n {
+			return fmt.Errorf("%v is less than %v", v, n)
+		}
+		return nil
+	}
+}
+
+// Max expects numbers, or the length of strings, slices and maps, to be no
+// greater than n.
+func Max(n float64) Rule {
+	return func(v interface{}) error {
+		if s, ok := size(v); ok && s > n {
+			return fmt.Errorf("%v is greater than %v", v, n)
+		}
+		return nil
+	}
+}
+
+// OneOf expects the value to match one of the supplied values.
+func OneOf(values ...string) Rule {
+	return func(v interface{}) error {
+		for _, o := range values {
+			if fmt.Sprint(v) == o {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is not one of %s", v, strings.Join(values, ", "))
+	}
+}
+
+// Match expects the value to match the regular expression, and will panic
+// if the expression cannot be compiled.
+func Match(pattern string) Rule {
+	re := regexp.MustCompile(pattern)
+	return func(v interface{}) error {
+		if !re.MatchString(fmt.Sprint(v)) {
+			return fmt.Errorf("%v does not match %s", v, pattern)
+		}
+		return nil
+	}
+}
+
+// NonEmpty expects a non-zero value, or a non-empty string, slice or map.
+func NonEmpty() Rule {
+	return func(v interface{}) error {
+		r := reflect.ValueOf(v)
+		switch {
+		case !r.IsValid():
+		case r.Kind() == reflect.String || r.Kind() == reflect.Slice || r.Kind() == reflect.Map:
+			if r.Len() > 0 {
+				return nil
+			}
+		case !r.IsZero():
+			return nil
+		}
+		return fmt.Errorf("value is empty")
+	}
+}
+
+// FileExists expects the value to be the path to an existing file, rather
+// than a directory.
+func FileExists() Rule {
+	return func(v interface{}) error {
+		fi, err := stat(fmt.Sprint(v))
+		if err == nil && fi.IsDir() {
+			return fmt.Errorf("%v is a directory", v)
+		}
+		return err
+	}
+}
+
+// Parses the validate tag used by Scan, which is a comma separated list of
+// min=n, max=n, oneof=a|b, nonempty, file, and regex=pattern, where regex
+// must be last since the pattern may contain commas.
+func parseRules(tag string) ([]Rule, error) {
+	var rules []Rule
+	for tag != "" {
+		var r string
+		if strings.HasPrefix(tag, "regex=") {
+			r, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			r, tag = tag[:i], tag[i+1:]
+		} else {
+			r, tag = tag, ""
+		}
+		kv := append(strings.SplitN(r, "=", 2), "")
+		switch kv[0] {
+		case "min", "max":
+			n, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				return rules, fmt.Errorf("bad %s rule, %s", kv[0], err)
+			} else if kv[0] == "min" {
+				rules = append(rules, Min(n))
+			} else {
+				rules = append(rules, Max(n))
+			}
+		case "oneof":
+			rules = append(rules, OneOf(strings.Split(kv[1], "|")...))
+		case "regex":
+			re, err := regexp.Compile(kv[1])
+			if err != nil {
+				return rules, fmt.Errorf("bad regex rule, %s", err)
+			}
+			rules = append(rules, Match(re.String()))
+		case "nonempty":
+			rules = append(rules, NonEmpty())
+		case "file":
+			rules = append(rules, FileExists())
+		default:
+			return rules, fmt.Errorf("unknown rule %s", r)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,10 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package gonf
+
+import "os"
+
+func owner(_ *os.File, _ os.FileInfo) {}
+
+func syncdir(_ string) error { return nil }
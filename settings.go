@@ -10,6 +10,8 @@ type setting struct {
 	Description string
 	Env         string
 	Options     []string
+	Required    bool
+	Secret      bool
 }
 
 // Check for a matching option, and whether that option is greedy.
@@ -32,5 +34,9 @@ func (s setting) String() string {
 	} else if s.Env != "" {
 		o += " (" + s.Env + ")"
 	}
-	return fmt.Sprintf("\t%-30s\n\t\t%s", o, s.Description)
+	d := s.Description
+	if s.Required {
+		d = strings.TrimSpace(d + " (required)")
+	}
+	return fmt.Sprintf("\t%-30s\n\t\t%s", o, d)
 }
@@ -0,0 +1,43 @@
+package gonf
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Installs a handler for the supplied signals (SIGHUP by default) which calls
+// Reload, and with it any hooks registered with OnReload, until the context
+// is cancelled.
+//
+// Errors other than no changes are delivered to the returned channel, which
+// is closed once the context is cancelled.  If the previous error has not
+// been received any new errors are discarded, so the channel may be safely
+// ignored.
+func (c *Config) ReloadOnSignal(ctx context.Context, signals ...os.Signal) <-chan error {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	errs := make(chan error, 1)
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, signals...)
+	go func() {
+		defer close(errs)
+		defer signal.Stop(s)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s:
+				if err := c.Reload(); err != nil && err != ErrNoChanges {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return errs
+}
@@ -0,0 +1,72 @@
+package gonf
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals are not supported on windows...")
+	}
+	stat = os.Stat
+	readfile = ioutil.ReadFile
+	d, e := ioutil.TempDir(os.TempDir(), "gonf")
+	if e != nil {
+		t.Fatal("failed to acquire temporary directory...")
+	}
+	defer os.RemoveAll(d)
+	cf := filepath.Join(d, "gonf.json")
+
+	mc := &mockConfig{}
+	c := &Config{configFile: cf}
+	c.Target(mc)
+	hooks := make(chan []Change, 10)
+	c.OnReload(nil)
+	c.OnReload(func(changes []Change) { hooks <- changes })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := c.ReloadOnSignal(ctx)
+	p, _ := os.FindProcess(os.Getpid())
+
+	// test reload and hooks after signal
+	ioutil.WriteFile(cf, []byte(`{"EnvString": "signaled"}`), 0600)
+	p.Signal(syscall.SIGHUP)
+	select {
+	case changes := <-hooks:
+		if mc.EnvString != "signaled" || len(changes) != 1 || changes[0].Name != "EnvString" {
+			t.Error("failed to reload on signal...")
+		}
+	case e := <-errs:
+		t.Errorf("unexpected error reloading on signal: %v", e)
+	case <-time.After(2 * time.Second):
+		t.Error("failed to run hooks after signal...")
+	}
+
+	// test errors are delivered
+	ioutil.WriteFile(cf, []byte(`not json`), 0600)
+	os.Chtimes(cf, time.Now(), time.Now().Add(time.Second))
+	p.Signal(syscall.SIGHUP)
+	select {
+	case <-errs:
+	case <-time.After(2 * time.Second):
+		t.Error("failed to deliver reload error...")
+	}
+
+	// test cancellation closes the channel
+	cancel()
+	select {
+	case _, ok := <-errs:
+		if ok {
+			t.Error("unexpected error after cancel...")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("failed to close after cancel...")
+	}
+}
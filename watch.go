@@ -0,0 +1,78 @@
+package gonf
+
+import (
+	"context"
+	"time"
+)
+
+var notify = inotify
+
+// Options for Watch, where the interval is used for polling when the system
+// does not support file notifications, and debounce is how long to wait for
+// a burst of writes to settle before reloading.  OnChange is called with the
+// settings that changed after each successful reload, and OnError with any
+// error other than no changes.
+type WatchOptions struct {
+	Interval time.Duration
+	Debounce time.Duration
+	OnChange func([]Change)
+	OnError  func(error)
+}
+
+func (c *Config) watched(o WatchOptions) {
+	if changes, err := c.reload(); err == ErrNoChanges {
+		return
+	} else if err != nil && o.OnError != nil {
+		o.OnError(err)
+	} else if err == nil && o.OnChange != nil {
+		o.OnChange(changes)
+	}
+}
+
+// Monitors the ConfigFile identified during Load and calls Reload when it is
+// modified, followed by any hooks registered with OnReload, blocking until
+// the context is cancelled.
+//
+// On linux this uses inotify to watch the directory, which supports editors
+// and tools that replace the file, otherwise it falls back to polling by the
+// interval using the modified time of the file.  The interval defaults to
+// one second, and the debounce to one hundred milliseconds.
+func (c *Config) Watch(ctx context.Context, o WatchOptions) error {
+	if c.ConfigFile() == "" {
+		return ErrEmptyConfig
+	}
+	if o.Interval <= 0 {
+		o.Interval = time.Second
+	}
+	if o.Debounce <= 0 {
+		o.Debounce = 100 * time.Millisecond
+	}
+	var poll <-chan time.Time
+	events, err := notify(ctx, c.ConfigFile())
+	if err != nil {
+		t := time.NewTicker(o.Interval)
+		defer t.Stop()
+		poll = t.C
+	}
+	debounce := time.NewTimer(o.Debounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-events:
+			if ok {
+				debounce.Reset(o.Debounce)
+			} else if events = nil; poll == nil {
+				t := time.NewTicker(o.Interval)
+				defer t.Stop()
+				poll = t.C
+			}
+		case <-debounce.C:
+			c.watched(o)
+		case <-poll:
+			c.watched(o)
+		}
+	}
+}
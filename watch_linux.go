@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+package gonf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+func inotify(ctx context.Context, path string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+	events := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		defer close(events)
+		buf := make([]byte, 4096)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for i := 0; i+syscall.SizeofInotifyEvent <= n; {
+				e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[i]))
+				name := buf[i+syscall.SizeofInotifyEvent : i+syscall.SizeofInotifyEvent+int(e.Len)]
+				if strings.TrimRight(string(name), "\x00") == filepath.Base(path) {
+					select {
+					case events <- struct{}{}:
+					default:
+					}
+				}
+				i += syscall.SizeofInotifyEvent + int(e.Len)
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package gonf
+
+import "context"
+
+func inotify(_ context.Context, _ string) (<-chan struct{}, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,91 @@
+package gonf
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	stat = os.Stat
+	readfile = ioutil.ReadFile
+	d, e := ioutil.TempDir(os.TempDir(), "gonf")
+	if e != nil {
+		t.Fatal("failed to acquire temporary directory...")
+	}
+	defer os.RemoveAll(d)
+	cf := filepath.Join(d, "gonf.json")
+
+	c := &Config{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// test without configFile
+	if c.Watch(ctx, WatchOptions{}) == nil {
+		t.Error("failed to identify empty configuration file name...")
+	}
+
+	for _, fallback := range []bool{false, true} {
+		mc := &mockConfig{}
+		c = &Config{configFile: cf}
+		c.Target(mc)
+		ioutil.WriteFile(cf, []byte(`{"EnvString": "one"}`), 0600)
+		if e := c.Reload(); e != nil || mc.EnvString != "one" {
+			t.Fatalf("failed initial reload: %v", e)
+		}
+
+		if fallback {
+			notify = func(context.Context, string) (<-chan struct{}, error) { return nil, ErrNotSupported }
+		}
+		changes, errs := make(chan []Change, 10), make(chan error, 10)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- c.Watch(ctx, WatchOptions{
+				Interval: 20 * time.Millisecond,
+				Debounce: 20 * time.Millisecond,
+				OnChange: func(c []Change) { changes <- c },
+				OnError:  func(e error) { errs <- e },
+			})
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		// test change is delivered
+		ioutil.WriteFile(cf, []byte(`{"EnvString": "two"}`), 0600)
+		os.Chtimes(cf, time.Now(), time.Now().Add(time.Second))
+		select {
+		case c := <-changes:
+			if mc.EnvString != "two" || len(c) != 1 || c[0].Name != "EnvString" || c[0].Old != "one" || c[0].New != "two" {
+				t.Error("failed to reload watched file...")
+			}
+		case e := <-errs:
+			t.Errorf("unexpected error watching file: %v", e)
+		case <-time.After(2 * time.Second):
+			t.Errorf("failed to detect change (fallback %v)...", fallback)
+		}
+
+		// test error is delivered
+		ioutil.WriteFile(cf, []byte(`not json`), 0600)
+		os.Chtimes(cf, time.Now(), time.Now().Add(2*time.Second))
+		select {
+		case <-errs:
+		case <-time.After(2 * time.Second):
+			t.Errorf("failed to deliver error (fallback %v)...", fallback)
+		}
+
+		// test cancellation stops watching
+		cancel()
+		select {
+		case e := <-done:
+			if e != nil {
+				t.Errorf("unexpected error after cancel: %v", e)
+			}
+		case <-time.After(2 * time.Second):
+			t.Error("failed to stop after cancel...")
+		}
+	}
+	notify = inotify
+}